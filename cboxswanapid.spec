@@ -31,6 +31,7 @@ mkdir -p %buildroot/etc/cboxswanapid
 mkdir -p %buildroot/etc/logrotate.d
 mkdir -p %buildroot/usr/lib/systemd/system
 mkdir -p %buildroot/var/log/cboxswanapid
+mkdir -p %buildroot/var/lib/cboxswanapid
 install -m 755 cboxswanapid	     %buildroot/usr/local/bin/cboxswanapid
 install -m 644 cboxswanapid.service    %buildroot/usr/lib/systemd/system/cboxswanapid.service
 install -m 644 cboxswanapid.yaml       %buildroot/etc/cboxswanapid/cboxswanapid.yaml
@@ -48,6 +49,7 @@ rm -rf %buildroot/
 /etc/cboxswanapid
 /etc/logrotate.d/cboxswanapid
 /var/log/cboxswanapid
+/var/lib/cboxswanapid
 /usr/lib/systemd/system/cboxswanapid.service
 /usr/local/bin/*
 %config(noreplace) /etc/cboxswanapid/cboxswanapid.yaml
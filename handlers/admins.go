@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/context"
+)
+
+// Admins are the users allowed to act on the projects of any user, given by
+// name or by the groups they belong to.
+type Admins struct {
+	Users  []string
+	Groups []string
+}
+
+// isAdmin tells whether the logged in user of the request is an admin.
+func (a *Admins) isAdmin(r *http.Request) bool {
+	username, _ := context.Get(r, "username").(string)
+	if username != "" && stringInSlice(username, a.Users) {
+		return true
+	}
+	groups, _ := context.Get(r, "groups").([]string)
+	for _, group := range groups {
+		if stringInSlice(group, a.Groups) {
+			return true
+		}
+	}
+	return false
+}
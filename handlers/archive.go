@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// Archive formats.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+var archiveContentTypes = map[string]string{
+	ArchiveZip:   "application/zip",
+	ArchiveTarGz: "application/gzip",
+}
+
+// ArchiveShare streams an archive of a project shared with the user. The share
+// script writes the archive on its standard output, or its JSON error if it fails
+// before writing anything. Projects larger than maxSize bytes are refused,
+// maxSize is also given to the script as the projects grow.
+func ArchiveShare(logger *zap.Logger, cboxShareScript string, maxSize int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		query := r.URL.Query()
+		sharer, project := query.Get("sharer"), query.Get("project")
+		if sharer == "" || project == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: sharer or project not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		format := query.Get("format")
+		if format == "" {
+			format = ArchiveZip
+		}
+		contentType, ok := archiveContentTypes[format]
+		if !ok {
+			logger.Error(fmt.Sprintf("Invalid archive format '%s'", format))
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid format %q", format))
+			return
+		}
+
+		share := checkSharedWith(logger, w, cboxShareScript, username, sharer, project)
+		if share == nil {
+			return
+		}
+		if maxSize > 0 && shareSize(share) > float64(maxSize) {
+			logger.Error(fmt.Sprintf("Project '%s' of %s is too large for an archive", project, sharer))
+			writeError(w, http.StatusForbidden, fmt.Sprintf("the project is larger than the archive limit of %d bytes", maxSize))
+			return
+		}
+
+		args := []string{"--json", "archive-share", "--format", format}
+		if maxSize > 0 {
+			args = append(args, "--max-size", strconv.FormatInt(maxSize, 10))
+		}
+		args = append(args, sharer, path.Clean(project), username)
+
+		logger.Info(fmt.Sprintf("cmd args %s", args))
+
+		cmd := exec.Command(cboxShareScript, args...)
+		// The script runs in its own process group to stop the archiver it started if the client goes away.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		errBuf := &bytes.Buffer{}
+		cmd.Stderr = errBuf
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error calling cmd %s: %s", cboxShareScript, err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := cmd.Start(); err != nil {
+			logger.Error(fmt.Sprintf("Error calling cmd %s: %s", cboxShareScript, err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		out := bufio.NewReader(stdout)
+		first, err := out.Peek(1)
+		if err != nil || first[0] == '{' {
+			jsonResponse, _ := ioutil.ReadAll(out)
+			err := cmd.Wait()
+			logger.Error(fmt.Sprintf("Error calling cmd %s %s %v: '%s'", cmd.Path, cmd.Args, err, errBuf.String()))
+			cmderr := CmdError{Statuscode: http.StatusInternalServerError}
+			json.Unmarshal(jsonResponse, &cmderr)
+			w.WriteHeader(cmderr.Statuscode)
+			w.Write(jsonResponse)
+			return
+		}
+
+		name := path.Base(strings.TrimSuffix(project, "/")) + "." + format
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+
+		if _, err := io.Copy(w, out); err != nil {
+			logger.Error(fmt.Sprintf("Error streaming the archive of project '%s' of %s: %s", project, sharer, err))
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		}
+		if err := cmd.Wait(); err != nil {
+			logger.Error(fmt.Sprintf("Error calling cmd %s %s %s: '%s'", cmd.Path, cmd.Args, err, errBuf.String()))
+			// Abort the response for the client not to take a truncated archive for a complete one.
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// sharedProjectParams returns the sharer and project query parameters, checking
+// that the project is shared with the user. Otherwise it writes the error and returns false.
+func sharedProjectParams(logger *zap.Logger, w http.ResponseWriter, r *http.Request, cboxShareScript, username string) (string, string, bool) {
+	query := r.URL.Query()
+	sharer, project := query.Get("sharer"), query.Get("project")
+	if sharer == "" || project == "" {
+		logger.Error(fmt.Sprintf("URL missing query parameter: sharer or project not specified"))
+		w.WriteHeader(http.StatusBadRequest)
+		return "", "", false
+	}
+	if checkSharedWith(logger, w, cboxShareScript, username, sharer, project) == nil {
+		return "", "", false
+	}
+	return sharer, project, true
+}
+
+// ShareTree lists the files and directories of a project shared with the user,
+// optionally of a subdirectory and down to a given depth.
+func ShareTree(logger *zap.Logger, cboxShareScript string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		sharer, project, ok := sharedProjectParams(logger, w, r, cboxShareScript, username)
+		if !ok {
+			return
+		}
+
+		args := []string{"--json", "list-share-tree"}
+
+		query := r.URL.Query()
+		if p := query.Get("path"); p != "" {
+			if !validHomePath(p) {
+				logger.Error(fmt.Sprintf("Invalid path '%s'", p))
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid path %q", p))
+				return
+			}
+			args = append(args, "--path", path.Clean(p))
+		}
+		if depth := query.Get("depth"); depth != "" {
+			if d, err := strconv.Atoi(depth); err != nil || d < 1 {
+				logger.Error(fmt.Sprintf("Invalid depth '%s'", depth))
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid depth %q", depth))
+				return
+			}
+			args = append(args, "--depth", depth)
+		}
+
+		args = append(args, sharer, path.Clean(project), username)
+
+		jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+		w.WriteHeader(statusCode)
+		w.Write(jsonResponse)
+	})
+}
+
+// ShareFile returns the content of a small file of a project shared with the user,
+// for previews. Files larger than maxSize bytes are refused by the share script.
+func ShareFile(logger *zap.Logger, cboxShareScript string, maxSize int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		file := r.URL.Query().Get("path")
+		if !validHomePath(file) {
+			logger.Error(fmt.Sprintf("Invalid path '%s'", file))
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid path %q", file))
+			return
+		}
+
+		sharer, project, ok := sharedProjectParams(logger, w, r, cboxShareScript, username)
+		if !ok {
+			return
+		}
+
+		args := []string{"--json", "read-share-file", "--max-size", strconv.FormatInt(maxSize, 10), sharer, path.Clean(project), username, path.Clean(file)}
+
+		jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+		w.WriteHeader(statusCode)
+		w.Write(jsonResponse)
+	})
+}
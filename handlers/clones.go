@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// CloneOrigin is the project a clone was made from.
+type CloneOrigin struct {
+	Sharer  string `json:"sharer"`
+	Project string `json:"project"`
+	// Version is the version of the original project the clone is up to date with,
+	// as reported by the share script.
+	Version string    `json:"version"`
+	Cloned  time.Time `json:"cloned"`
+}
+
+// Clones keeps the origin of the clones of each user, by path of the clone.
+type Clones struct {
+	store Store
+}
+
+const clonesBucket = "clones"
+
+// NewClones returns the clone origins kept in the store.
+func NewClones(store Store) *Clones {
+	return &Clones{store: store}
+}
+
+func (c *Clones) get(username string) (map[string]*CloneOrigin, error) {
+	origins := map[string]*CloneOrigin{}
+	if _, err := c.store.Get(clonesBucket, username, &origins); err != nil {
+		return nil, err
+	}
+	return origins, nil
+}
+
+// record sets the origin of a clone of the user.
+func (c *Clones) record(username, clone string, origin *CloneOrigin) error {
+	origins := map[string]*CloneOrigin{}
+	return updateUserData(c.store, clonesBucket, username, &origins, func() error {
+		origins[path.Clean(clone)] = origin
+		return nil
+	})
+}
+
+// jobVersion returns the version of the original project given in the result
+// of a clone or pull job, or the start time of the job if the share script does not report it.
+func jobVersion(job *Job) string {
+	var result struct {
+		Version string `json:"version"`
+	}
+	json.Unmarshal(job.Result, &result)
+	if result.Version != "" {
+		return result.Version
+	}
+	return strconv.FormatInt(job.Created.Unix(), 10)
+}
+
+// Filter is a listing filter setting the cloned_from field of the projects of the user that are clones.
+func (c *Clones) Filter() ListingFilter {
+	return func(r *http.Request, username string, listing *Listing) error {
+		origins, err := c.get(username)
+		if err != nil {
+			return err
+		}
+		for _, share := range listing.Shares {
+			project, _ := share["project"].(string)
+			if origin, ok := origins[path.Clean(project)]; ok {
+				share["cloned_from"] = origin
+			}
+		}
+		return nil
+	}
+}
+
+// ListClones lists the clones of the user with their origin.
+func ListClones(logger *zap.Logger, clones *Clones) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		origins, err := clones.get(username)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error loading clones: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		type clone struct {
+			Project    string       `json:"project"`
+			ClonedFrom *CloneOrigin `json:"cloned_from"`
+		}
+		list := []clone{}
+		for project, origin := range origins {
+			list = append(list, clone{project, origin})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Project < list[j].Project })
+
+		encoded, _ := json.Marshal(map[string]interface{}{"clones": list})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
+
+// cloneOrigin returns the origin of the clone given by the project query parameter,
+// checking that the original project is still shared with the user. Otherwise
+// it writes the error and returns nil.
+func cloneOrigin(logger *zap.Logger, w http.ResponseWriter, r *http.Request, cboxShareScript string, clones *Clones, username string) (string, *CloneOrigin) {
+	project := r.URL.Query().Get("project")
+	if project == "" {
+		logger.Error(fmt.Sprintf("URL missing query parameter: project not specified"))
+		w.WriteHeader(http.StatusBadRequest)
+		return "", nil
+	}
+	if !checkShareRoot(logger, w, r, project) {
+		return "", nil
+	}
+
+	origins, err := clones.get(username)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error loading clones: %s", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", nil
+	}
+	origin, ok := origins[path.Clean(project)]
+	if !ok {
+		logger.Error(fmt.Sprintf("Project '%s' of %s is not a clone", project, username))
+		writeError(w, http.StatusNotFound, "the project is not a clone")
+		return "", nil
+	}
+
+	share, jsonResponse, statusCode := findSharedWith(logger, cboxShareScript, username, origin.Sharer, origin.Project)
+	if statusCode != http.StatusOK {
+		w.WriteHeader(statusCode)
+		w.Write(jsonResponse)
+		return "", nil
+	}
+	if share == nil {
+		logger.Error(fmt.Sprintf("Project '%s' of %s is no longer shared with %s", origin.Project, origin.Sharer, username))
+		writeError(w, http.StatusNotFound, "the original project is no longer shared with the user")
+		return "", nil
+	}
+	return path.Clean(project), origin
+}
+
+// CloneChanges lists the files of the original project of a clone changed since
+// the clone, or the last pull.
+func CloneChanges(logger *zap.Logger, cboxShareScript string, clones *Clones) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		project, origin := cloneOrigin(logger, w, r, cboxShareScript, clones, username)
+		if origin == nil {
+			return
+		}
+
+		args := []string{"--json", "diff-clone", origin.Sharer, path.Clean(origin.Project), username, path.Clean(project), origin.Version}
+
+		jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+		w.WriteHeader(statusCode)
+		w.Write(jsonResponse)
+	})
+}
+
+// pullRequest is the optional body of a pull, selecting the files to update.
+type pullRequest struct {
+	Files []string `json:"files"`
+}
+
+// PullClone starts a job copying into a clone the files changed in its original
+// project, all of them or those selected in the body, and then records the new version of the clone.
+func PullClone(logger *zap.Logger, cboxShareScript string, jobs *Jobs, clones *Clones) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		var req pullRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			logger.Error(fmt.Sprintf("Cannot unmarshal JSON request body"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		args := []string{"--json", "pull-clone", "--progress"}
+		for _, file := range req.Files {
+			if !validHomePath(file) {
+				logger.Error(fmt.Sprintf("Invalid file '%s'", file))
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid file %q", file))
+				return
+			}
+			args = append(args, "--file", path.Clean(file))
+		}
+
+		project, origin := cloneOrigin(logger, w, r, cboxShareScript, clones, username)
+		if origin == nil {
+			return
+		}
+
+		args = append(args, origin.Sharer, path.Clean(origin.Project), username, path.Clean(project), origin.Version)
+
+		startJob(logger, w, jobs, cboxShareScript, "pull", username, project, args, func(job *Job) {
+			// A partial pull leaves the other changes to pull later.
+			if len(req.Files) > 0 {
+				return
+			}
+			updated := *origin
+			updated.Version = jobVersion(job)
+			if err := clones.record(username, project, &updated); err != nil {
+				logger.Error(fmt.Sprintf("Error storing the version of clone '%s': %s", project, err))
+			}
+		})
+	})
+}
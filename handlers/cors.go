@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// CORSConfig holds the CORS settings shared by all the API endpoints.
+type CORSConfig struct {
+	// AllowedHeaders are the request headers the browser may send (case insensitive).
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers the browser may read.
+	ExposedHeaders []string
+	// AllowCredentials allows requests with cookies or TLS client certificates.
+	AllowCredentials bool
+	// MaxAge is the time in seconds the browser may cache the preflight response.
+	MaxAge int
+}
+
+// CORS-safelisted request headers, always allowed.
+var corsSafelistedHeaders = []string{"accept", "accept-language", "content-language"}
+
+// parseHeaderList parses a comma separated list of header names in lower case.
+func parseHeaderList(list string) []string {
+	var headers []string
+	for _, h := range splitList(list, ",") {
+		headers = append(headers, strings.ToLower(h))
+	}
+	return headers
+}
+
+func (c *CORSConfig) headerAllowed(header string) bool {
+	if stringInSlice(header, corsSafelistedHeaders) {
+		return true
+	}
+	for _, h := range c.AllowedHeaders {
+		if strings.ToLower(h) == header {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORSConfig) setCommonHeaders(w http.ResponseWriter) {
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// CORS checks the Origin header of the request and sets the CORS response headers.
+// Requests without Origin header are passed on without CORS headers, the handlers
+// needing an origin reject them with checkOrigin.
+func CORS(logger *zap.Logger, origins *Origins, config *CORSConfig, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Add("Vary", "Origin")
+
+		if r.Header.Get("Origin") == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if !CORSProcessOriginHeader(logger, w, r, origins) {
+			return
+		}
+
+		config.setCommonHeaders(w)
+		if len(config.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(config.ExposedHeaders, ", "))
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Options answers the CORS preflight requests of an endpoint accepting allowedMethods.
+func Options(logger *zap.Logger, allowedMethods []string, origins *Origins, config *CORSConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		if !CORSProcessOriginHeader(logger, w, r, origins) {
+			return
+		}
+
+		x := r.Header.Get("Access-Control-Request-Method")
+
+		if !stringInSlice(strings.ToUpper(x), allowedMethods) {
+			logger.Error(fmt.Sprintf("OPTIONS: Wrong or missing Access-Control-Request-Method header: '%s' ", x))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		requested := parseHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+		for _, h := range requested {
+			if !config.headerAllowed(h) {
+				logger.Error(fmt.Sprintf("OPTIONS: Header '%s' in Access-Control-Request-Headers is not allowed", h))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		config.setCommonHeaders(w)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+		if len(requested) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		if config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(config.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// checkOrigin writes Bad Request and returns false if the request has not
+// been accepted by the CORS middleware.
+func checkOrigin(logger *zap.Logger, w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := context.Get(r, "origin").(*OriginSettings); !ok {
+		logger.Error("Missing Origin header")
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// shareState is the part of the share listing the ETag is derived from.
+type shareState struct {
+	Shares []struct {
+		Project    string `json:"project"`
+		SharedWith []struct {
+			Name        string `json:"name"`
+			Entity      string `json:"entity"`
+			Permissions string `json:"permissions"`
+			Expires     string `json:"expires"`
+		} `json:"shared_with"`
+	} `json:"shares"`
+}
+
+// shareETag derives a strong ETag from a share listing, with the guest invitations if any.
+// Only the sharees, their permissions and expiration are taken into account, in a canonical order.
+func shareETag(listing []byte) (string, error) {
+	var state shareState
+	if err := json.Unmarshal(listing, &state); err != nil {
+		return "", err
+	}
+	if state.Shares == nil {
+		return "", fmt.Errorf("not a share listing")
+	}
+	var lines []string
+	for _, share := range state.Shares {
+		for _, sharee := range share.SharedWith {
+			lines = append(lines, strings.Join([]string{share.Project, sharee.Entity, sharee.Name, sharee.Permissions, sharee.Expires}, "\x00"))
+		}
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// setShareETag sets the ETag response header from a share listing, if it can be parsed.
+func setShareETag(w http.ResponseWriter, listing []byte) {
+	if etag, err := shareETag(listing); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+// etagMatches implements the comparison of the If-Match header.
+func etagMatches(ifMatch, etag string) bool {
+	for _, v := range strings.Split(ifMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// keyLocks is a set of mutexes identified by a key, created on demand.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	users int
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{locks: map[string]*keyLock{}}
+}
+
+// shareLocks serializes the modifications of the shares of each project,
+// so that the If-Match check and the update are not interleaved with other updates.
+var shareLocks = newKeyLocks()
+
+// shareLockKey is the key of the lock of the shares of a project of owner,
+// whether the path has a trailing slash or not.
+func shareLockKey(owner, project string) string {
+	return owner + ":" + path.Clean(project)
+}
+
+func (p *keyLocks) lock(key string) {
+	p.mu.Lock()
+	l, ok := p.locks[key]
+	if !ok {
+		l = &keyLock{}
+		p.locks[key] = l
+	}
+	l.users++
+	p.mu.Unlock()
+	l.Lock()
+}
+
+func (p *keyLocks) unlock(key string) {
+	p.mu.Lock()
+	l := p.locks[key]
+	l.users--
+	if l.users == 0 {
+		delete(p.locks, key)
+	}
+	p.mu.Unlock()
+	l.Unlock()
+}
+
+// CheckIfMatch serializes the modifications of the shares of a project and, if
+// the request has the If-Match header, replies Precondition Failed when the
+// current ETag of the shares, guest invitations included, does not match.
+func CheckIfMatch(logger *zap.Logger, cboxShareScript string, guests *Guests, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+		project := r.URL.Query().Get("project")
+		if project == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: project not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// The path is validated before it reaches the share script or keys a lock.
+		if !checkShareRoot(logger, w, r, project) {
+			return
+		}
+
+		key := shareLockKey(username, project)
+		shareLocks.lock(key)
+		defer shareLocks.unlock(key)
+
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch != "" {
+			args := []string{"--json", "list-shared-by", "--project", path.Clean(project), username}
+			jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+			if statusCode != http.StatusOK {
+				w.WriteHeader(statusCode)
+				w.Write(jsonResponse)
+				return
+			}
+			etag, err := shareETag(withGuests(r, username, guests, jsonResponse))
+			if err != nil {
+				logger.Error(fmt.Sprintf("Cannot parse the shares of project '%s': %s", project, err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !etagMatches(ifMatch, etag) {
+				logger.Info(fmt.Sprintf("Shares of project '%s' modified: If-Match %s, ETag %s", project, ifMatch, etag))
+				w.Header().Set("ETag", etag)
+				writeError(w, http.StatusPreconditionFailed, "the shares of the project have been modified")
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
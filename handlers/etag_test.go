@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+func TestCheckIfMatchRejectsInvalidPaths(t *testing.T) {
+	called := false
+	store, _, cleanup := guestStore(t)
+	defer cleanup()
+	handler := CheckIfMatch(zap.NewNop(), "/nonexistent/share-script", NewGuests(store), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	for _, project := range []string{"", "/eos/user/b/bob/SWAN_projects/A", "..", "../alice/SWAN_projects/A", "SWAN_projects/../../A"} {
+		req := httptest.NewRequest("PUT", "/swanapi/v1/share?project="+project, nil)
+		req.Header.Set("If-Match", `"etag"`)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest || called {
+			t.Errorf("%q: got status %d, handler called %t", project, rec.Code, called)
+		}
+	}
+}
+
+func TestShareLockKey(t *testing.T) {
+	if shareLockKey("bob", "SWAN_projects/A/") != shareLockKey("bob", "SWAN_projects/A") {
+		t.Error("the trailing slash changes the lock key")
+	}
+	if shareLockKey("bob", "SWAN_projects/A") == shareLockKey("alice", "SWAN_projects/A") {
+		t.Error("the owner does not change the lock key")
+	}
+}
+
+func TestETagCoversGuests(t *testing.T) {
+	store, dir, cleanup := guestStore(t)
+	defer cleanup()
+	script := filepath.Join(dir, "share-script")
+	listing := `{"shares":[{"project":"SWAN_projects/A/","path":"/eos/user/b/bob/SWAN_projects/A/","shared_by":"bob","shared_with":[{"name":"alice","entity":"u","permissions":"r"}]}]}`
+	if err := ioutil.WriteFile(script, []byte("#!/bin/bash\necho '"+listing+"'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	guests := NewGuests(store)
+	logger := zap.NewNop()
+	serve := func(handler http.Handler, method, ifMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/swanapi/v1/share?project=SWAN_projects/A/", nil)
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		context.Set(r, "username", "bob")
+		context.Set(r, "origin", &OriginSettings{})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		context.Clear(r)
+		return rec
+	}
+	get := Shared(logger, script, "list-shared-by", true, guests.Filter())
+	check := CheckIfMatch(logger, script, guests, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	before := serve(get, "GET", "").Header().Get("ETag")
+	if err := guests.update("bob", "SWAN_projects/A/", []*Sharee{{Name: "carol@example.org", Entity: EntityEmail, Permissions: PermRead}}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	after := serve(get, "GET", "").Header().Get("ETag")
+	if before == "" || before == after {
+		t.Fatalf("ETag %q unchanged by a guest invitation", before)
+	}
+	if rec := serve(check, "PATCH", before); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale ETag: got status %d, want %d", rec.Code, http.StatusPreconditionFailed)
+	}
+	if rec := serve(check, "PATCH", after); rec.Code != http.StatusOK {
+		t.Errorf("current ETag: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
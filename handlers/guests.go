@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// GuestInvitation is a project shared with a user outside CERN by email. It
+// becomes a share of the user account once they log in through the external
+// accounts realm with that email verified.
+type GuestInvitation struct {
+	Email       string     `json:"email"`
+	Owner       string     `json:"owner"`
+	Project     string     `json:"project"`
+	Permissions string     `json:"permissions"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	Invited     time.Time  `json:"invited"`
+}
+
+func (inv *GuestInvitation) expired() bool {
+	return inv.Expires != nil && !inv.Expires.After(time.Now())
+}
+
+// Guests keeps the pending guest invitations. The invitations are stored by
+// owner, and the owners who invited each email are kept in a separate bucket to
+// find them at login.
+type Guests struct {
+	store Store
+}
+
+const (
+	guestsBucket      = "guest-invitations"
+	emailGuestsBucket = "email-guest-invitations"
+)
+
+// guestPrefix starts the usernames of the guests, followed by their subject in
+// the external accounts realm. A '+' is never part of a CERN login, so that a
+// guest cannot take the identity of a CERN user.
+const guestPrefix = "guest+"
+
+func isGuestUsername(username string) bool {
+	return strings.HasPrefix(username, guestPrefix)
+}
+
+// validUsername returns true if username can be the CERN user owning a project:
+// passed to the share script, it must not start with '-' nor contain the ':' and
+// '/' separating the entities and the paths, and the guests own no projects.
+func validUsername(username string) bool {
+	return username != "" && !strings.HasPrefix(username, "-") && !strings.ContainsAny(username, ":/ \t\n") &&
+		strings.IndexFunc(username, unicode.IsControl) < 0 && !isGuestUsername(username)
+}
+
+// checkUsername writes Bad Request and returns false if the username given in the
+// query parameter is not valid.
+func checkUsername(logger *zap.Logger, w http.ResponseWriter, param, username string) bool {
+	if !validUsername(username) {
+		logger.Error(fmt.Sprintf("Invalid %s '%s'", param, username))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s %q", param, username))
+		return false
+	}
+	return true
+}
+
+// NewGuests returns the guest invitations kept in the store.
+func NewGuests(store Store) *Guests {
+	return &Guests{store: store}
+}
+
+// list returns the guest invitations of the owner.
+func (g *Guests) list(owner string) ([]*GuestInvitation, error) {
+	invitations := []*GuestInvitation{}
+	if _, err := g.store.Get(guestsBucket, owner, &invitations); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// invited tells whether the owner invited guests to the project.
+func (g *Guests) invited(owner, project string) (bool, error) {
+	invitations, err := g.list(owner)
+	if err != nil {
+		return false, err
+	}
+	for _, inv := range invitations {
+		if path.Clean(inv.Project) == path.Clean(project) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// update changes the guest invitations of a project of the owner: with replace,
+// the invitations of the project are replaced by add, otherwise add are added
+// or updated and the emails in remove are removed.
+func (g *Guests) update(owner, project string, add []*Sharee, remove []string, replace bool) error {
+	invitations := []*GuestInvitation{}
+	for _, sharee := range add {
+		invitations = append(invitations, &GuestInvitation{
+			Email:       strings.ToLower(sharee.Name),
+			Owner:       owner,
+			Project:     project,
+			Permissions: sharee.Permissions,
+			Expires:     sharee.Expires,
+			Invited:     time.Now().UTC(),
+		})
+	}
+	return g.updateInvitations(owner, project, invitations, remove, replace)
+}
+
+// updateInvitations is update with the invitations to add, of the owner and project.
+func (g *Guests) updateInvitations(owner, project string, add []*GuestInvitation, remove []string, replace bool) error {
+	invitations := []*GuestInvitation{}
+	emails := map[string]bool{}
+	err := updateUserData(g.store, guestsBucket, owner, &invitations, func() error {
+		removed := map[string]bool{}
+		for _, email := range remove {
+			removed[strings.ToLower(email)] = true
+		}
+		for _, inv := range add {
+			removed[inv.Email] = true
+		}
+		kept := []*GuestInvitation{}
+		for _, inv := range invitations {
+			if path.Clean(inv.Project) == path.Clean(project) && (replace || removed[inv.Email]) {
+				emails[inv.Email] = true
+				continue
+			}
+			kept = append(kept, inv)
+		}
+		for _, inv := range add {
+			emails[inv.Email] = true
+			kept = append(kept, inv)
+		}
+		invitations = kept
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return g.updateEmails(owner, invitations, emails)
+}
+
+// updateEmails updates the owners of the emails, after their invitations by the
+// owner were changed to invitations.
+func (g *Guests) updateEmails(owner string, invitations []*GuestInvitation, emails map[string]bool) error {
+	for email := range emails {
+		invited := false
+		for _, inv := range invitations {
+			if inv.Email == email {
+				invited = true
+			}
+		}
+		owners := []string{}
+		err := updateUserData(g.store, emailGuestsBucket, email, &owners, func() error {
+			kept := []string{}
+			for _, o := range owners {
+				if o != owner {
+					kept = append(kept, o)
+				}
+			}
+			if invited {
+				kept = append(kept, owner)
+			}
+			owners = kept
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transfer moves the guest invitations of a project of the owner to the
+// recipient, who owns the project once the share script has moved it to their
+// home under the same path.
+func (g *Guests) transfer(owner, project, recipient string) error {
+	invitations, err := g.list(owner)
+	if err != nil {
+		return err
+	}
+	moved := []*GuestInvitation{}
+	for _, inv := range invitations {
+		if path.Clean(inv.Project) == path.Clean(project) {
+			copied := *inv
+			copied.Owner = recipient
+			moved = append(moved, &copied)
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	if err := g.updateInvitations(recipient, project, moved, nil, false); err != nil {
+		return err
+	}
+	return g.updateInvitations(owner, project, nil, nil, true)
+}
+
+// removeExpired removes the guest invitations expired at the given time, which
+// are not listed anymore but would otherwise stay in the store.
+func (g *Guests) removeExpired(now time.Time) error {
+	owners, err := g.store.Keys(guestsBucket)
+	if err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		invitations := []*GuestInvitation{}
+		emails := map[string]bool{}
+		err := updateUserData(g.store, guestsBucket, owner, &invitations, func() error {
+			kept := []*GuestInvitation{}
+			for _, inv := range invitations {
+				if inv.Expires != nil && !inv.Expires.After(now) {
+					emails[inv.Email] = true
+					continue
+				}
+				kept = append(kept, inv)
+			}
+			invitations = kept
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := g.updateEmails(owner, invitations, emails); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEmail returns the guest invitations of the email.
+func (g *Guests) forEmail(email string) ([]*GuestInvitation, error) {
+	owners := []string{}
+	if _, err := g.store.Get(emailGuestsBucket, email, &owners); err != nil {
+		return nil, err
+	}
+	out := []*GuestInvitation{}
+	for _, owner := range owners {
+		invitations, err := g.list(owner)
+		if err != nil {
+			return nil, err
+		}
+		for _, inv := range invitations {
+			if inv.Email == email {
+				out = append(out, inv)
+			}
+		}
+	}
+	return out, nil
+}
+
+// accept converts the guest invitations of the email into shares with the user.
+// The invitations whose share fails are kept to be converted at the next login.
+func (g *Guests) accept(logger *zap.Logger, cboxShareScript, email, username string) {
+	invitations, err := g.forEmail(email)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error loading the guest invitations of %s: %s", email, err))
+		return
+	}
+	for _, inv := range invitations {
+		if !inv.expired() {
+			sharee := &Sharee{Name: username, Entity: EntityUser, Permissions: inv.Permissions, Expires: inv.Expires}
+			key := shareLockKey(inv.Owner, inv.Project)
+			shareLocks.lock(key)
+			args := []string{"--json", "patch-share", inv.Owner, path.Clean(inv.Project), "--add", sharee.arg()}
+			_, statusCode := runShareScript(logger, cboxShareScript, args)
+			shareLocks.unlock(key)
+			if statusCode != http.StatusOK {
+				logger.Error(fmt.Sprintf("Cannot share project '%s' of %s with guest %s (%s)", inv.Project, inv.Owner, username, email))
+				continue
+			}
+			logger.Info(fmt.Sprintf("Guest invitation of %s to project '%s' of %s accepted by %s", email, inv.Project, inv.Owner, username))
+		}
+		if err := g.update(inv.Owner, inv.Project, nil, []string{email}, false); err != nil {
+			logger.Error(fmt.Sprintf("Error removing the guest invitation of %s to project '%s' of %s: %s", email, inv.Project, inv.Owner, err))
+		}
+	}
+}
+
+// pending tells whether the email has guest invitations which are not expired,
+// like the ones whose share failed at login.
+func (g *Guests) pending(logger *zap.Logger, email string) bool {
+	invitations, err := g.forEmail(email)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error loading the guest invitations of %s: %s", email, err))
+		return false
+	}
+	for _, inv := range invitations {
+		if !inv.expired() {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedWithGuest tells whether a project is shared with the guest account.
+func sharedWithGuest(logger *zap.Logger, cboxShareScript, username string) bool {
+	jsonResponse, statusCode := runShareScript(logger, cboxShareScript, []string{"--json", "list-shared-with", username})
+	if statusCode != http.StatusOK {
+		logger.Error(fmt.Sprintf("Cannot list the projects shared with guest %s: %s", username, jsonResponse))
+		return false
+	}
+	listing, err := parseListing(jsonResponse)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Cannot parse the projects shared with guest %s: %s", username, err))
+		return false
+	}
+	return len(listing.Shares) > 0
+}
+
+// guestSharee is a guest invitation as a sharee of the share listings.
+func guestSharee(inv *GuestInvitation) map[string]interface{} {
+	sharee := map[string]interface{}{
+		"name":        inv.Email,
+		"entity":      EntityEmail,
+		"permissions": inv.Permissions,
+		"created":     inv.Invited.Format(time.RFC3339),
+		"status":      InvitationPending,
+	}
+	if inv.Expires != nil {
+		sharee["expires"] = inv.Expires.Format(time.RFC3339)
+	}
+	return sharee
+}
+
+// Filter is the listing filter of the projects of the user adding the pending
+// guest invitations to the sharees of the projects, and the projects shared only
+// with guests. The path of the latter, not shared in the storage yet, is the
+// project path relative to the home of the user. With the project query
+// parameter, only this project is listed.
+func (g *Guests) Filter() ListingFilter {
+	return func(r *http.Request, username string, listing *Listing) error {
+		invitations, err := g.list(username)
+		if err != nil {
+			return err
+		}
+		only := r.URL.Query().Get("project")
+		byProject := map[string][]*GuestInvitation{}
+		projects := map[string]string{}
+		for _, inv := range invitations {
+			p := path.Clean(inv.Project)
+			if inv.expired() || (only != "" && p != path.Clean(only)) {
+				continue
+			}
+			byProject[p] = append(byProject[p], inv)
+			projects[p] = inv.Project
+		}
+		for _, share := range listing.Shares {
+			project, _ := share["project"].(string)
+			p := path.Clean(project)
+			sharedWith, _ := share["shared_with"].([]interface{})
+			for _, inv := range byProject[p] {
+				sharedWith = append(sharedWith, guestSharee(inv))
+			}
+			if _, ok := byProject[p]; ok {
+				share["shared_with"] = sharedWith
+				delete(byProject, p)
+			}
+		}
+		for p, guests := range byProject {
+			sharedWith := []interface{}{}
+			for _, inv := range guests {
+				sharedWith = append(sharedWith, guestSharee(inv))
+			}
+			listing.Shares = append(listing.Shares, map[string]interface{}{
+				"project":     projects[p],
+				"path":        p,
+				"shared_by":   username,
+				"shared_with": sharedWith,
+			})
+		}
+		return nil
+	}
+}
+
+// hasSharees tells whether a share listing of the share script has any sharee.
+func hasSharees(listing []byte) bool {
+	var state shareState
+	if err := json.Unmarshal(listing, &state); err != nil {
+		return true
+	}
+	for _, share := range state.Shares {
+		if len(share.SharedWith) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// splitGuests separates the email sharees from the user and group ones.
+func splitGuests(sharees []*Sharee) (guests []*Sharee, others []*Sharee) {
+	for _, sharee := range sharees {
+		if sharee.Entity == EntityEmail {
+			guests = append(guests, sharee)
+		} else {
+			others = append(others, sharee)
+		}
+	}
+	return guests, others
+}
+
+// withGuests adds the guest invitations to the share listing returned by the
+// share script, or returns it untouched if it is not a listing.
+func withGuests(r *http.Request, username string, guests *Guests, jsonResponse []byte) []byte {
+	filtered, err := applyListingFilters(r, username, jsonResponse, []ListingFilter{guests.Filter()})
+	if err != nil {
+		return jsonResponse
+	}
+	return filtered
+}
+
+// AcceptGuestInvitations gives the user authenticated through the external
+// accounts realm their guest username, converts the guest invitations of their
+// verified email into shares, and calls handler, which mints their token.
+func AcceptGuestInvitations(logger *zap.Logger, cboxShareScript string, guests *Guests, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		v := context.Get(r, "username")
+		subject, _ := v.(string)
+		if subject == "" {
+			logger.Error("Missing subject of the guest")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		username := guestPrefix + subject
+		context.Set(r, "username", username)
+		context.Set(r, "guest", true)
+
+		email, _ := context.Get(r, "email").(string)
+		verified, _ := context.Get(r, "email_verified").(bool)
+
+		invited := false
+		if email != "" && verified {
+			guests.accept(logger, cboxShareScript, strings.ToLower(email), username)
+			invited = guests.pending(logger, strings.ToLower(email))
+		} else {
+			logger.Info(fmt.Sprintf("No verified email for guest %s", username))
+		}
+
+		// Only the guests with a project shared with them, or still to be, get a token.
+		if !invited && !sharedWithGuest(logger, cboxShareScript, username) {
+			logger.Error(fmt.Sprintf("No project shared with guest %s", username))
+			writeError(w, http.StatusForbidden, "no project shared with the guest")
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// guestListing is the response to a change of the share of a project that only
+// touched the guest invitations, where the share script is not called.
+func guestListing(logger *zap.Logger, w http.ResponseWriter, r *http.Request, cboxShareScript string, guests *Guests, username, project string) {
+	args := []string{"--json", "list-shared-by", "--project", path.Clean(project), username}
+	jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+	if statusCode == http.StatusOK {
+		jsonResponse = withGuests(r, username, guests, jsonResponse)
+		setShareETag(w, jsonResponse)
+	}
+	w.WriteHeader(statusCode)
+	w.Write(jsonResponse)
+}
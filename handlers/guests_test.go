@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// guestStore returns a store in a temporary directory, removed by the returned function.
+func guestStore(t *testing.T) (Store, string, func()) {
+	dir, err := ioutil.TempDir("", "guests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(filepath.Join(dir, "store.json"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, dir, func() { os.RemoveAll(dir) }
+}
+
+func signedToken(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestGuestLogin(t *testing.T) {
+	store, dir, cleanup := guestStore(t)
+	defer cleanup()
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "share-script")
+	// Once accepted, the invitation of alice is a share with her guest account.
+	err := ioutil.WriteFile(script, []byte(`#!/bin/bash
+echo "$@" >> `+calls+`
+if [ "$2 $3" = "list-shared-with guest+alice" ]; then
+	echo '{"shares":[{"project":"SWAN_projects/A/","shared_by":"alice","shared_with":[{"name":"guest+alice","entity":"u"}]}]}'
+else
+	echo '{}'
+fi
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guests := NewGuests(store)
+	if err := guests.update("alice", "SWAN_projects/A/", []*Sharee{{Name: "Carol@Example.org", Entity: EntityEmail, Permissions: PermRead}}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	login := AcceptGuestInvitations(zap.NewNop(), script, guests, Token2(zap.NewNop(), "key"))
+	r := httptest.NewRequest("GET", "/swanapi/v2/authenticate/guest", nil)
+	context.Set(r, "username", "alice")
+	context.Set(r, "email", "carol@example.org")
+	context.Set(r, "email_verified", true)
+	rec := httptest.NewRecorder()
+	login.ServeHTTP(rec, r)
+	context.Clear(r)
+
+	var response struct {
+		Token string `json:"authtoken"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	token, err := jwt.Parse(response.Token, func(*jwt.Token) (interface{}, error) { return []byte("key"), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["username"] != "guest+alice" || claims["guest"] != true {
+		t.Errorf("unexpected guest token claims %v", claims)
+	}
+	out, _ := ioutil.ReadFile(calls)
+	if !strings.Contains(string(out), "patch-share alice SWAN_projects/A --add u:guest+alice:r") {
+		t.Errorf("invitation not accepted by the guest account: %q", out)
+	}
+	if invitations, _ := guests.list("alice"); len(invitations) != 0 {
+		t.Errorf("invitation not removed: %v", invitations)
+	}
+
+	r = httptest.NewRequest("GET", "/swanapi/v2/authenticate/guest", nil)
+	context.Set(r, "username", "mallory")
+	context.Set(r, "email", "mallory@example.org")
+	context.Set(r, "email_verified", true)
+	rec = httptest.NewRecorder()
+	login.ServeHTTP(rec, r)
+	context.Clear(r)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("guest without projects: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestCheckScopeGuests(t *testing.T) {
+	for _, c := range []struct {
+		guest  bool
+		scope  string
+		status int
+	}{
+		{false, ScopeShare, http.StatusOK},
+		{true, ScopeRead, http.StatusOK},
+		{true, ScopeShare, http.StatusForbidden},
+		{true, ScopeClone, http.StatusForbidden},
+		{true, ScopeSearch, http.StatusForbidden},
+	} {
+		handler := CheckScope(zap.NewNop(), c.scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest("GET", "/swanapi/v1/sharing", nil)
+		context.Set(r, "origin", &OriginSettings{})
+		context.Set(r, "guest", c.guest)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		context.Clear(r)
+		if rec.Code != c.status {
+			t.Errorf("guest %t, scope %s: got status %d, want %d", c.guest, c.scope, rec.Code, c.status)
+		}
+	}
+}
+
+func TestCheckJWTTokenGuestNamespace(t *testing.T) {
+	for _, c := range []struct {
+		claims jwt.MapClaims
+		status int
+	}{
+		{jwt.MapClaims{"username": "alice"}, http.StatusOK},
+		{jwt.MapClaims{"username": "guest+alice", "guest": true}, http.StatusOK},
+		{jwt.MapClaims{"username": "alice", "guest": true}, http.StatusUnauthorized},
+		{jwt.MapClaims{"username": "guest+alice"}, http.StatusUnauthorized},
+	} {
+		handler := CheckJWTToken(zap.NewNop(), "key", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest("GET", "/swanapi/v1/shared", nil)
+		r.Header.Set("Authorization", "Bearer "+signedToken(t, c.claims))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		context.Clear(r)
+		if rec.Code != c.status {
+			t.Errorf("%v: got status %d, want %d", c.claims, rec.Code, c.status)
+		}
+	}
+}
+
+func TestGuestsTransfer(t *testing.T) {
+	store, _, cleanup := guestStore(t)
+	defer cleanup()
+	guests := NewGuests(store)
+	carol := []*Sharee{{Name: "carol@example.org", Entity: EntityEmail, Permissions: PermReadWrite}}
+	if err := guests.update("alice", "SWAN_projects/A/", carol, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := guests.update("alice", "SWAN_projects/B/", carol, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := guests.transfer("alice", "SWAN_projects/A", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	invitations, _ := guests.forEmail("carol@example.org")
+	owners := map[string]string{}
+	for _, inv := range invitations {
+		owners[inv.Project] = inv.Owner
+	}
+	if len(invitations) != 2 || owners["SWAN_projects/A/"] != "bob" || owners["SWAN_projects/B/"] != "alice" {
+		t.Errorf("unexpected invitations after the transfer: %v", owners)
+	}
+	if list, _ := guests.list("alice"); len(list) != 1 {
+		t.Errorf("alice has %d invitations, want 1", len(list))
+	}
+}
+
+func TestGuestsRemoveExpired(t *testing.T) {
+	store, _, cleanup := guestStore(t)
+	defer cleanup()
+	guests := NewGuests(store)
+	past := time.Now().Add(-time.Hour)
+	if err := guests.update("alice", "SWAN_projects/A/", []*Sharee{
+		{Name: "carol@example.org", Entity: EntityEmail, Permissions: PermRead, Expires: &past},
+		{Name: "dave@example.org", Entity: EntityEmail, Permissions: PermRead},
+	}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := guests.removeExpired(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, _ := guests.list("alice"); len(list) != 1 || list[0].Email != "dave@example.org" {
+		t.Errorf("unexpected invitations after the reaping: %v", list)
+	}
+	if invitations, _ := guests.forEmail("carol@example.org"); len(invitations) != 0 {
+		t.Errorf("expired invitation still found by email: %v", invitations)
+	}
+	owners := []string{}
+	if found, _ := store.Get(emailGuestsBucket, "carol@example.org", &owners); found && len(owners) != 0 {
+		t.Errorf("owners of the expired invitation kept: %v", owners)
+	}
+}
+
+func TestValidUsername(t *testing.T) {
+	for _, username := range []string{"alice", "svc-swan", "a.b"} {
+		if !validUsername(username) {
+			t.Errorf("%q rejected", username)
+		}
+	}
+	for _, username := range []string{"", "-h", "--project", "u:alice", "../alice", "a b", "guest+alice"} {
+		if validUsername(username) {
+			t.Errorf("%q accepted", username)
+		}
+	}
+}
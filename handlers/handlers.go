@@ -9,7 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"os/exec"
-	"regexp"
+	"path"
 	"strings"
 	"time"
 
@@ -79,10 +79,10 @@ func CheckNothing(logger *zap.Logger, handler http.Handler) http.Handler {
 	})
 }
 
-func CheckOIDCToken(logger *zap.Logger, contx ctx.Context, verifier *oidc.IDTokenVerifier, handler http.Handler, allowFrom string) http.Handler {
+func CheckOIDCToken(logger *zap.Logger, contx ctx.Context, verifier *oidc.IDTokenVerifier, handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if !CORSProcessOriginHeader(logger, w, r, allowFrom) {
+		if !checkOrigin(logger, w, r) {
 			return
 		}
 
@@ -103,7 +103,9 @@ func CheckOIDCToken(logger *zap.Logger, contx ctx.Context, verifier *oidc.IDToke
 		}
 
 		var claims struct {
-			Subject string `json:"sub"`
+			Subject       string `json:"sub"`
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"email_verified"`
 		}
 		if err := idToken.Claims(&claims); err != nil {
 			logger.Error("error getting token subject")
@@ -112,35 +114,44 @@ func CheckOIDCToken(logger *zap.Logger, contx ctx.Context, verifier *oidc.IDToke
 		}
 
 		context.Set(r, "username", claims.Subject)
+		if claims.Email != "" {
+			context.Set(r, "email", claims.Email)
+			context.Set(r, "email_verified", claims.EmailVerified)
+		}
 		handler.ServeHTTP(w, r)
 	})
 }
 
-func CheckHostAllowed(origin url.URL, allowFrom string, logger *zap.Logger) bool {
+func CheckHostAllowed(origin url.URL, origins *Origins, logger *zap.Logger) (*OriginSettings, bool) {
 
 	if origin.Scheme != "https" {
-		logger.Info(fmt.Sprintf("***** Only https scheme is supported. Origin is %s", origin))
-		return false
+		logger.Info(fmt.Sprintf("***** Only https scheme is supported. Origin is %s", origin.String()))
+		return nil, false
 	}
 
-	// TODO: case insensitive
-	matched, _ := regexp.MatchString(allowFrom, origin.Host)
+	settings, matched := origins.Match(&origin)
 
-	logger.Info(fmt.Sprintf("***** Checking Allowed Host:  %s matches %s => %b", origin.String(), allowFrom, matched))
+	logger.Info(fmt.Sprintf("***** Checking Allowed Host:  %s matches %s => %t", origin.String(), origins, matched))
 
-	return matched
+	return settings, matched
 
 }
 
-func Token(logger *zap.Logger, signKey string, allowFrom string, shibReferer string) http.Handler {
+func Token(logger *zap.Logger, signKey string, origins *Origins, shibReferer string, shib *ShibConfig) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		logger.Info(formatRequest(r))
 
-		username := r.Header.Get("adfs_login") // this comes back from shibolleth (the name of the header depends on shibd configuration)
+		if !shib.fromTrustedProxy(r) {
+			logger.Error(fmt.Sprintf("Request from '%s' is not coming from a trusted proxy", r.RemoteAddr))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 
-		if username == "" {
-			logger.Error("Request header 'adfs_login' is empty or not set")
+		identity := shib.identity(r) // this comes back from shibolleth (the name of the headers depends on shibd configuration)
+
+		if identity.Username == "" {
+			logger.Error(fmt.Sprintf("Request header '%s' is empty or not set", shib.LoginHeader))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -148,7 +159,7 @@ func Token(logger *zap.Logger, signKey string, allowFrom string, shibReferer str
 		m, err := url.ParseQuery(r.URL.RawQuery)
 
 		if err != nil {
-			logger.Error(fmt.Sprintf("URL query parsing error: %s '%s' ", err))
+			logger.Error(fmt.Sprintf("URL query parsing error: %s '%s' ", err, r.URL.RawQuery))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -180,20 +191,30 @@ func Token(logger *zap.Logger, signKey string, allowFrom string, shibReferer str
 			return
 		}
 
-		if !CheckHostAllowed(*referer, allowFrom, logger) {
-			logger.Error(fmt.Sprintf("Referer host '%s' does not match allowFrom pattern '%s'", referer.Host, allowFrom))
+		settings, ok := CheckHostAllowed(*referer, origins, logger)
+		if !ok {
+			logger.Error(fmt.Sprintf("Referer host '%s' does not match allowFrom patterns '%s'", referer.Host, origins))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
 		//logger.Info(fmt.Sprintf("***** ALLOWED_HOST: %s",referer_host))
 
-		expire := time.Now().Add(time.Duration(3600) * time.Second) // TODO(labkode): expire data in config
+		expire := time.Now().Add(time.Duration(settings.TokenLifetime) * time.Second)
 
 		token := jwt.New(jwt.GetSigningMethod("HS256"))
 		claims := token.Claims.(jwt.MapClaims)
-		claims["username"] = username
-		claims["exp"] = expire.UnixNano()
+		claims["username"] = identity.Username
+		if identity.DisplayName != "" {
+			claims["display_name"] = identity.DisplayName
+		}
+		if identity.Email != "" {
+			claims["email"] = identity.Email
+		}
+		if len(identity.Groups) > 0 {
+			claims["groups"] = identity.Groups
+		}
+		claims["exp"] = expire.Unix()
 		tokenString, _ := token.SignedString([]byte(signKey))
 
 		response := &struct {
@@ -215,12 +236,15 @@ func Token2(logger *zap.Logger, signKey string) http.Handler {
 		v := context.Get(r, "username")
 		username, _ := v.(string)
 
-		expire := time.Now().Add(time.Duration(3600) * time.Second) // TODO(labkode): expire data in config
+		expire := time.Now().Add(time.Duration(originSettings(r).TokenLifetime) * time.Second)
 
 		token := jwt.New(jwt.GetSigningMethod("HS256"))
 		claims := token.Claims.(jwt.MapClaims)
 		claims["username"] = username
-		claims["exp"] = expire.UnixNano()
+		claims["exp"] = expire.Unix()
+		if guest, _ := context.Get(r, "guest").(bool); guest {
+			claims["guest"] = true
+		}
 		tokenString, _ := token.SignedString([]byte(signKey))
 
 		response := &struct {
@@ -262,8 +286,33 @@ func CheckJWTToken(logger *zap.Logger, signKey string, handler http.Handler) htt
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		// The guests and the CERN users have separate namespaces, which the tokens must not mix.
+		guest, _ := claims["guest"].(bool)
+		if guest != isGuestUsername(username) {
+			logger.Error(fmt.Sprintf("jwt token username %s does not match its guest claim", username))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 
 		context.Set(r, "username", username)
+		if guest {
+			context.Set(r, "guest", true)
+		}
+		if displayName, ok := claims["display_name"].(string); ok {
+			context.Set(r, "display_name", displayName)
+		}
+		if email, ok := claims["email"].(string); ok {
+			context.Set(r, "email", email)
+		}
+		if groups, ok := claims["groups"].([]interface{}); ok {
+			names := []string{}
+			for _, g := range groups {
+				if name, ok := g.(string); ok {
+					names = append(names, name)
+				}
+			}
+			context.Set(r, "groups", names)
+		}
 		fmt.Println(r.URL)
 		handler.ServeHTTP(w, r)
 	})
@@ -284,7 +333,7 @@ func Handle200(logger *zap.Logger) http.Handler {
 }
 
 // Handle CORS Origin header and return true if the request is allowed to continue
-func CORSProcessOriginHeader(logger *zap.Logger, w http.ResponseWriter, r *http.Request, allowFrom string) bool {
+func CORSProcessOriginHeader(logger *zap.Logger, w http.ResponseWriter, r *http.Request, origins *Origins) bool {
 
 	origin, err := url.Parse(r.Header.Get("Origin"))
 
@@ -294,12 +343,14 @@ func CORSProcessOriginHeader(logger *zap.Logger, w http.ResponseWriter, r *http.
 		return false
 	}
 
-	if !CheckHostAllowed(*origin, allowFrom, logger) {
-		logger.Error(fmt.Sprintf("Origin URL '%s' does not match allowFrom pattern '%s'", origin, allowFrom))
+	settings, ok := CheckHostAllowed(*origin, origins, logger)
+	if !ok {
+		logger.Error(fmt.Sprintf("Origin URL '%s' does not match allowFrom patterns '%s'", origin, origins))
 		w.WriteHeader(http.StatusBadRequest)
 		return false
 	}
 
+	context.Set(r, "origin", settings)
 	w.Header().Set("Access-Control-Allow-Origin", origin.String())
 	return true
 }
@@ -313,35 +364,6 @@ func stringInSlice(str string, list []string) bool {
 	return false
 }
 
-func Options(logger *zap.Logger, allowedMethods []string, allowFrom string) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		if !CORSProcessOriginHeader(logger, w, r, allowFrom) {
-			return
-		}
-
-		x := r.Header.Get("Access-Control-Request-Headers")
-
-		if strings.ToUpper(x) != "AUTHORIZATION" {
-			logger.Error(fmt.Sprintf("OPTIONS: Wrong or missing Access-Control-Request-Headers header: '%s'", x))
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		x = r.Header.Get("Access-Control-Request-Method")
-
-		if !stringInSlice(strings.ToUpper(x), allowedMethods) {
-			logger.Error(fmt.Sprintf("OPTIONS: Wrong or missing Access-Control-Request-Method header: '%' ", x))
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ","))
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization")
-
-	})
-}
-
 /* ------------------------ */
 
 func executeCMD(cmd *exec.Cmd) (*bytes.Buffer, *bytes.Buffer, error) {
@@ -355,9 +377,9 @@ func executeCMD(cmd *exec.Cmd) (*bytes.Buffer, *bytes.Buffer, error) {
 	return outBuf, errBuf, err
 }
 
-func Search(logger *zap.Logger, allowFrom, cboxgroupdUrl, cboxgroupdSecret string) http.Handler {
+func Search(logger *zap.Logger, cboxgroupdUrl, cboxgroupdSecret string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !CORSProcessOriginHeader(logger, w, r, allowFrom) {
+		if !checkOrigin(logger, w, r) {
 			return
 		}
 
@@ -380,10 +402,12 @@ func Search(logger *zap.Logger, allowFrom, cboxgroupdUrl, cboxgroupdSecret strin
 	})
 }
 
-func CloneShare(logger *zap.Logger, cboxShareScript string, allowFrom string) http.Handler {
+// CloneShare starts the clone of a project shared with the user as a job and,
+// when it succeeds, records the origin of the clone.
+func CloneShare(logger *zap.Logger, cboxShareScript string, jobs *Jobs, clones *Clones) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if !CORSProcessOriginHeader(logger, w, r, allowFrom) {
+		if !checkOrigin(logger, w, r) {
 			return
 		}
 
@@ -399,7 +423,7 @@ func CloneShare(logger *zap.Logger, cboxShareScript string, allowFrom string) ht
 		m, err := url.ParseQuery(r.URL.RawQuery)
 
 		if err != nil {
-			logger.Error(fmt.Sprintf("URL query parsing error: %s '%s' ", err))
+			logger.Error(fmt.Sprintf("URL query parsing error: %s '%s' ", err, r.URL.RawQuery))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -428,35 +452,55 @@ func CloneShare(logger *zap.Logger, cboxShareScript string, allowFrom string) ht
 			return
 		}
 
-		args := []string{"--json", "clone-share", sharer, shared_project, username, cloned_project}
+		if !checkUsername(logger, w, "sharer", sharer) {
+			return
+		}
 
-		logger.Info(fmt.Sprintf("cmd args %s", args))
+		if !checkShareRoot(logger, w, r, cloned_project) {
+			return
+		}
 
-		cmd := exec.Command(cboxShareScript, args...)
+		args := []string{"--json", "clone-share", "--progress"}
 
-		jsonResponse, errBuf, err := executeCMD(cmd)
+		onConflict := m.Get("on_conflict")
+		if onConflict != "" && onConflict != ConflictFail {
+			if !stringInSlice(onConflict, conflictModes) {
+				logger.Error(fmt.Sprintf("Invalid on_conflict mode '%s'", onConflict))
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid on_conflict mode %q", onConflict))
+				return
+			}
+			args = append(args, "--on-conflict", onConflict)
+		}
 
+		var req cloneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			logger.Error(fmt.Sprintf("Cannot unmarshal JSON request body"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		filterArgs, err := req.args()
 		if err != nil {
-
-			logger.Error(fmt.Sprintf("Error calling cmd %s %s %s: '%s'", cmd.Path, cmd.Args, err, errBuf.String()))
-
-			cmderr := CmdError{Statuscode: http.StatusInternalServerError}
-			json.Unmarshal(jsonResponse.Bytes(), &cmderr)
-
-			// TODO: inject error string if applicable
-			w.WriteHeader(cmderr.Statuscode)
-			//return
+			logger.Error(fmt.Sprintf("Invalid clone filter: %s", err))
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
 		}
+		args = append(args, filterArgs...)
 
-		w.Write(jsonResponse.Bytes())
+		args = append(args, sharer, path.Clean(shared_project), username, path.Clean(cloned_project))
 
+		startJob(logger, w, jobs, cboxShareScript, "clone", username, cloned_project, args, func(job *Job) {
+			origin := &CloneOrigin{Sharer: sharer, Project: path.Clean(shared_project), Version: jobVersion(job), Cloned: job.Created}
+			if err := clones.record(username, job.Destination, origin); err != nil {
+				logger.Error(fmt.Sprintf("Error storing the origin of clone '%s': %s", job.Destination, err))
+			}
+		})
 	})
 }
 
-func DeleteShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Handler {
+func DeleteShare(logger *zap.Logger, cboxShareScript string, guests *Guests) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if !CORSProcessOriginHeader(logger, w, r, allowFrom) {
+		if !checkOrigin(logger, w, r) {
 			return
 		}
 
@@ -470,7 +514,7 @@ func DeleteShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Han
 		m, err := url.ParseQuery(r.URL.RawQuery)
 
 		if err != nil {
-			logger.Error(fmt.Sprintf("URL query parsing error: %s '%s' ", err))
+			logger.Error(fmt.Sprintf("URL query parsing error: %s '%s' ", err, r.URL.RawQuery))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -483,7 +527,36 @@ func DeleteShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Han
 			return
 		}
 
-		args := []string{"--json", "delete-share", username, project}
+		if !checkShareRoot(logger, w, r, project) {
+			return
+		}
+
+		invited, err := guests.invited(username, project)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error loading guest invitations: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if invited {
+			listing, statusCode := runShareScript(logger, cboxShareScript, []string{"--json", "list-shared-by", "--project", path.Clean(project), username})
+			if statusCode != http.StatusOK {
+				w.WriteHeader(statusCode)
+				w.Write(listing)
+				return
+			}
+			// The project is shared only with guests: the share script has no share to delete.
+			if !hasSharees(listing) {
+				if err := guests.update(username, project, nil, nil, true); err != nil {
+					logger.Error(fmt.Sprintf("Error removing guest invitations: %s", err))
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.Write([]byte("{}"))
+				return
+			}
+		}
+
+		args := []string{"--json", "delete-share", username, path.Clean(project)}
 
 		logger.Info(fmt.Sprintf("cmd args %s", args))
 
@@ -501,6 +574,10 @@ func DeleteShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Han
 			// TODO: inject error string if applicable
 			w.WriteHeader(cmderr.Statuscode)
 			//return
+		} else if err := guests.update(username, project, nil, nil, true); err != nil {
+			logger.Error(fmt.Sprintf("Error removing guest invitations: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 
 		w.Write(jsonResponse.Bytes())
@@ -508,10 +585,10 @@ func DeleteShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Han
 	})
 }
 
-func UpdateShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Handler {
+func UpdateShare(logger *zap.Logger, cboxShareScript string, guests *Guests) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if !CORSProcessOriginHeader(logger, w, r, allowFrom) {
+		if !checkOrigin(logger, w, r) {
 			return
 		}
 
@@ -525,7 +602,7 @@ func UpdateShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Han
 		m, err := url.ParseQuery(r.URL.RawQuery)
 
 		if err != nil {
-			logger.Error(fmt.Sprintf("URL query parsing error: %s '%s' ", err))
+			logger.Error(fmt.Sprintf("URL query parsing error: %s '%s' ", err, r.URL.RawQuery))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -538,15 +615,14 @@ func UpdateShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Han
 			return
 		}
 
-		args := []string{"--json", "update-share", username, project}
-
-		type Sharee struct {
-			Name   string `json:"name"`   // name of user or group
-			Entity string `json:"entity"` // "u" is user, "egroup" is group
+		if !checkShareRoot(logger, w, r, project) {
+			return
 		}
 
+		args := []string{"--json", "update-share", username, path.Clean(project)}
+
 		type ShareRequest struct {
-			ShareWith []Sharee `json:"share_with"`
+			ShareWith []*Sharee `json:"share_with"`
 		}
 
 		var share_request ShareRequest
@@ -557,21 +633,43 @@ func UpdateShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Han
 			return
 		}
 
-		// TODO: BadRequest if empty ShareWith array
-
 		if len(share_request.ShareWith) == 0 {
 			logger.Error(fmt.Sprintf("Empty request"))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		logger.Info(fmt.Sprintf("request %s", share_request))
+		for _, share := range share_request.ShareWith {
+			if err := share.validate(); err != nil {
+				logger.Error(fmt.Sprintf("Invalid sharee: %s", err))
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
 
-		for i := range share_request.ShareWith {
-			// FIXME: TODO: sanitize names
-			// FIXME: TODO: check for missing fields, e.g. empty name or empty entity
-			share := share_request.ShareWith[i]
-			args = append(args, share.Entity+":"+share.Name)
+		// The guests invited by email are kept by the daemon, the script only gets the users and groups.
+		emailSharees, sharees := splitGuests(share_request.ShareWith)
+		if len(sharees) == 0 {
+			// update-share needs a sharee: the users and groups, if any, are removed with delete-share instead.
+			listing, statusCode := runShareScript(logger, cboxShareScript, []string{"--json", "list-shared-by", "--project", path.Clean(project), username})
+			if statusCode == http.StatusOK && hasSharees(listing) {
+				listing, statusCode = runShareScript(logger, cboxShareScript, []string{"--json", "delete-share", username, path.Clean(project)})
+			}
+			if statusCode != http.StatusOK {
+				w.WriteHeader(statusCode)
+				w.Write(listing)
+				return
+			}
+			if err := guests.update(username, project, emailSharees, nil, true); err != nil {
+				logger.Error(fmt.Sprintf("Error storing guest invitations: %s", err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			guestListing(logger, w, r, cboxShareScript, guests, username, project)
+			return
+		}
+		for _, share := range sharees {
+			args = append(args, share.arg())
 		}
 
 		logger.Info(fmt.Sprintf("cmd args %s", args))
@@ -590,6 +688,16 @@ func UpdateShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Han
 			// TODO: inject error string if applicable
 			w.WriteHeader(cmderr.Statuscode)
 			//return
+		} else {
+			if err := guests.update(username, project, emailSharees, nil, true); err != nil {
+				logger.Error(fmt.Sprintf("Error storing guest invitations: %s", err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			listing := withGuests(r, username, guests, jsonResponse.Bytes())
+			setShareETag(w, listing)
+			w.Write(listing)
+			return
 		}
 
 		w.Write(jsonResponse.Bytes())
@@ -597,10 +705,11 @@ func UpdateShare(logger *zap.Logger, cboxShareScript, allowFrom string) http.Han
 	})
 }
 
-func Shared(logger *zap.Logger, cboxShareScript, allowFrom string, action string, requireProject bool) http.Handler {
+// Shared returns a share listing of the user, after applying the filters.
+func Shared(logger *zap.Logger, cboxShareScript string, action string, requireProject bool, filters ...ListingFilter) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if !CORSProcessOriginHeader(logger, w, r, allowFrom) {
+		if !checkOrigin(logger, w, r) {
 			return
 		}
 
@@ -616,7 +725,7 @@ func Shared(logger *zap.Logger, cboxShareScript, allowFrom string, action string
 			m, err := url.ParseQuery(r.URL.RawQuery)
 
 			if err != nil {
-				logger.Error(fmt.Sprintf("URL query parsing error: %s '%s' ", err))
+				logger.Error(fmt.Sprintf("URL query parsing error: %s '%s' ", err, r.URL.RawQuery))
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
@@ -628,23 +737,16 @@ func Shared(logger *zap.Logger, cboxShareScript, allowFrom string, action string
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-		}
-
-		type shareInfo struct {
-			User string `json:"user"`
-			Path string `json:"path"`
-			Size int    `json:"size"`
-			Date int64  `json:"date"`
-		}
 
-		type response struct {
-			Shared []*shareInfo `json:"shared"`
+			if !checkShareRoot(logger, w, r, project) {
+				return
+			}
 		}
 
 		args := []string{"--json", action}
 
 		if project != "" {
-			args = append(args, "--project", project)
+			args = append(args, "--project", path.Clean(project))
 		}
 
 		args = append(args, username)
@@ -665,6 +767,29 @@ func Shared(logger *zap.Logger, cboxShareScript, allowFrom string, action string
 			// TODO: inject error string if applicable
 			w.WriteHeader(cmderr.Statuscode)
 			//return
+		} else {
+			if len(filters) > 0 {
+				filtered, err := applyListingFilters(r, username, jsonResponse.Bytes(), filters)
+				if err, ok := err.(*listingRequestError); ok {
+					logger.Error(fmt.Sprintf("Invalid listing request: %s", err))
+					writeError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				if err != nil {
+					logger.Error(fmt.Sprintf("Error filtering share listing: %s", err))
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				// The ETag covers the sharees added by the filters, such as the guests.
+				if requireProject {
+					setShareETag(w, filtered)
+				}
+				w.Write(filtered)
+				return
+			}
+			if requireProject {
+				setShareETag(w, jsonResponse.Bytes())
+			}
 		}
 
 		w.Write(jsonResponse.Bytes())
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// HiddenShares keeps the projects shared with the users they have hidden from their listing.
+type HiddenShares struct {
+	prefs *sharePrefs
+}
+
+// NewHiddenShares returns the hidden shares kept in the store.
+func NewHiddenShares(store Store) *HiddenShares {
+	return &HiddenShares{prefs: &sharePrefs{store: store, bucket: "hidden"}}
+}
+
+// Filter is the listing filter of /shared: it sets the hidden flag of each share
+// and leaves out the hidden ones, unless the include_hidden query parameter is true.
+func (h *HiddenShares) Filter() ListingFilter {
+	return func(r *http.Request, username string, listing *Listing) error {
+		hidden, err := h.prefs.get(username)
+		if err != nil {
+			return err
+		}
+		includeHidden := r.URL.Query().Get("include_hidden") == "true"
+		shares := []map[string]interface{}{}
+		for _, share := range listing.Shares {
+			_, isHidden := hidden[shareKey(share)]
+			share["hidden"] = isHidden
+			if !isHidden || includeHidden {
+				shares = append(shares, share)
+			}
+		}
+		listing.Shares = shares
+		return nil
+	}
+}
+
+// HideShare hides (or, if hide is false, unhides) a project shared with the user.
+func HideShare(logger *zap.Logger, cboxShareScript string, h *HiddenShares, hide bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		query := r.URL.Query()
+		sharer, project := query.Get("sharer"), query.Get("project")
+		if sharer == "" || project == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: sharer or project not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		share := checkSharedWith(logger, w, cboxShareScript, username, sharer, project)
+		if share == nil {
+			return
+		}
+
+		value := ""
+		if hide {
+			value = "true"
+		}
+		if err := h.prefs.set(username, shareKey(share), value); err != nil {
+			logger.Error(fmt.Sprintf("Error storing hidden share: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		share["hidden"] = hide
+		encoded, _ := json.Marshal(share)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
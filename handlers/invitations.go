@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// Invitation statuses of a project shared with the user.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationMuted    = "muted"
+)
+
+var invitationStatuses = []string{InvitationPending, InvitationAccepted, InvitationDeclined, InvitationMuted}
+
+// Invitations keeps the status of the projects shared with the users.
+// Projects without status are pending.
+type Invitations struct {
+	prefs *sharePrefs
+}
+
+// NewInvitations returns the invitations kept in the store.
+func NewInvitations(store Store) *Invitations {
+	return &Invitations{prefs: &sharePrefs{store: store, bucket: "invitations"}}
+}
+
+func (inv *Invitations) setStatus(username, key, status string) error {
+	if status == InvitationPending {
+		status = ""
+	}
+	return inv.prefs.set(username, key, status)
+}
+
+// filter sets the status of each share of the listing and keeps those for which keep returns true.
+func (inv *Invitations) filter(username string, listing *Listing, keep func(status string) bool) error {
+	statuses, err := inv.prefs.get(username)
+	if err != nil {
+		return err
+	}
+	shares := []map[string]interface{}{}
+	for _, share := range listing.Shares {
+		status, ok := statuses[shareKey(share)]
+		if !ok {
+			status = InvitationPending
+		}
+		share["status"] = status
+		if keep(status) {
+			shares = append(shares, share)
+		}
+	}
+	listing.Shares = shares
+	return nil
+}
+
+// StatusFilter is the listing filter of /shared: it sets the invitation status of each
+// share and, if the status query parameter is given, keeps only the shares with that status.
+func (inv *Invitations) StatusFilter() ListingFilter {
+	return func(r *http.Request, username string, listing *Listing) error {
+		wanted := r.URL.Query().Get("status")
+		return inv.filter(username, listing, func(status string) bool {
+			return wanted == "" || status == wanted
+		})
+	}
+}
+
+// PendingFilter is the listing filter of /invitations: it keeps the pending shares,
+// and the muted ones if the include_muted query parameter is true.
+func (inv *Invitations) PendingFilter() ListingFilter {
+	return func(r *http.Request, username string, listing *Listing) error {
+		includeMuted := r.URL.Query().Get("include_muted") == "true"
+		return inv.filter(username, listing, func(status string) bool {
+			return status == InvitationPending || (includeMuted && status == InvitationMuted)
+		})
+	}
+}
+
+// findSharedWith returns the project shared with the user by sharer, or nil if it is not shared with them.
+func findSharedWith(logger *zap.Logger, cboxShareScript, username, sharer, project string) (map[string]interface{}, []byte, int) {
+	args := []string{"--json", "list-shared-with", username}
+	jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+	if statusCode != http.StatusOK {
+		return nil, jsonResponse, statusCode
+	}
+	listing, err := parseListing(jsonResponse)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Cannot parse the projects shared with %s: %s", username, err))
+		return nil, nil, http.StatusInternalServerError
+	}
+	for _, share := range listing.Shares {
+		sharedBy, _ := share["shared_by"].(string)
+		sharedProject, _ := share["project"].(string)
+		if sharedBy == sharer && path.Clean(sharedProject) == path.Clean(project) {
+			return share, nil, http.StatusOK
+		}
+	}
+	return nil, nil, http.StatusOK
+}
+
+// checkSharedWith returns the project shared with the user by sharer. If it is not
+// shared with them, or the share script fails, it writes the error and returns nil.
+func checkSharedWith(logger *zap.Logger, w http.ResponseWriter, cboxShareScript, username, sharer, project string) map[string]interface{} {
+	share, jsonResponse, statusCode := findSharedWith(logger, cboxShareScript, username, sharer, project)
+	if statusCode != http.StatusOK {
+		w.WriteHeader(statusCode)
+		w.Write(jsonResponse)
+		return nil
+	}
+	if share == nil {
+		logger.Error(fmt.Sprintf("Project '%s' of %s is not shared with %s", project, sharer, username))
+		writeError(w, http.StatusNotFound, "project not shared with the user")
+		return nil
+	}
+	return share
+}
+
+// UpdateInvitation accepts, declines or mutes a project shared with the user.
+func UpdateInvitation(logger *zap.Logger, cboxShareScript string, inv *Invitations) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		query := r.URL.Query()
+		sharer, project := query.Get("sharer"), query.Get("project")
+		if sharer == "" || project == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: sharer or project not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Status string `json:"status"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Error(fmt.Sprintf("Cannot unmarshal JSON request body"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !stringInSlice(req.Status, invitationStatuses) {
+			logger.Error(fmt.Sprintf("Invalid invitation status '%s'", req.Status))
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid status %q", req.Status))
+			return
+		}
+
+		share := checkSharedWith(logger, w, cboxShareScript, username, sharer, project)
+		if share == nil {
+			return
+		}
+
+		if err := inv.setStatus(username, shareKey(share), req.Status); err != nil {
+			logger.Error(fmt.Sprintf("Error storing invitation status: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		share["status"] = req.Status
+		encoded, _ := json.Marshal(share)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
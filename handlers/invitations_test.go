@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestFindSharedWithCleansPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "invitations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "share-script")
+	listing := `{"shares":[{"project":"SWAN_projects/A/","shared_by":"alice","shared_with":[{"name":"bob","entity":"u"}]}]}`
+	if err := ioutil.WriteFile(script, []byte("#!/bin/bash\necho '"+listing+"'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		sharer, project string
+		found           bool
+	}{
+		{"alice", "SWAN_projects/A/", true},
+		{"alice", "SWAN_projects/A", true},
+		{"alice", "SWAN_projects//A/.", true},
+		{"carol", "SWAN_projects/A", false},
+		{"alice", "SWAN_projects/B", false},
+	} {
+		share, _, statusCode := findSharedWith(zap.NewNop(), script, "bob", c.sharer, c.project)
+		if statusCode != http.StatusOK || (share != nil) != c.found {
+			t.Errorf("%s %q: got status %d and share %v, want found %t", c.sharer, c.project, statusCode, share, c.found)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Job states.
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+var jobStates = []string{JobRunning, JobSucceeded, JobFailed, JobCancelled}
+
+// JobProgress is the progress of a job, as reported by the share script.
+type JobProgress struct {
+	Bytes      int64 `json:"bytes"`
+	Files      int64 `json:"files"`
+	TotalBytes int64 `json:"total_bytes,omitempty"`
+	TotalFiles int64 `json:"total_files,omitempty"`
+}
+
+// jobLine is a line reported by the share script while a job runs.
+type jobLine struct {
+	JobProgress
+	Destination string `json:"destination"`
+}
+
+// Job is a long running command of the share script, like the clone of a project.
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Owner       string          `json:"owner"`
+	State       string          `json:"state"`
+	Progress    JobProgress     `json:"progress"`
+	Destination string          `json:"destination,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Statuscode  int             `json:"statuscode,omitempty"`
+	Created     time.Time       `json:"created"`
+	Finished    *time.Time      `json:"finished,omitempty"`
+
+	cmd       *exec.Cmd
+	cancelled bool
+}
+
+// Jobs runs the jobs of the users and keeps them, in memory, until retention
+// after they finish.
+type Jobs struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	retention time.Duration
+}
+
+// NewJobs returns an empty set of jobs.
+func NewJobs(retention time.Duration) *Jobs {
+	return &Jobs{jobs: map[string]*Job{}, retention: retention}
+}
+
+// prune removes the jobs finished for longer than the retention. It must be called with the lock held.
+func (j *Jobs) prune() {
+	for id, job := range j.jobs {
+		if job.Finished != nil && time.Since(*job.Finished) > j.retention {
+			delete(j.jobs, id)
+		}
+	}
+}
+
+// snapshot returns a copy of the job, safe to encode without the lock.
+func (j *Jobs) snapshot(job *Job) *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	c := *job
+	return &c
+}
+
+// get returns the job of the user, or nil if there is no such job.
+func (j *Jobs) get(username, id string) *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.prune()
+	job := j.jobs[id]
+	if job == nil || job.Owner != username {
+		return nil
+	}
+	c := *job
+	return &c
+}
+
+// list returns the jobs of the user.
+func (j *Jobs) list(username string) []*Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.prune()
+	jobs := []*Job{}
+	for _, job := range j.jobs {
+		if job.Owner == username {
+			c := *job
+			jobs = append(jobs, &c)
+		}
+	}
+	return jobs
+}
+
+// cancel stops the job of the user if it is running. It returns false if there is no such job.
+func (j *Jobs) cancel(logger *zap.Logger, username, id string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job := j.jobs[id]
+	if job == nil || job.Owner != username {
+		return false
+	}
+	if job.State == JobRunning && !job.cancelled {
+		job.cancelled = true
+		if err := syscall.Kill(-job.cmd.Process.Pid, syscall.SIGTERM); err != nil {
+			logger.Error(fmt.Sprintf("Error cancelling job %s: %s", id, err))
+		}
+	}
+	return true
+}
+
+// start runs the share script in the background with the --progress option,
+// with which it reports its progress as JSON lines on the standard error:
+// {"bytes": 1024, "files": 3, "total_bytes": 4096, "total_files": 10}
+// A line can also give the path the job actually writes to, when the script
+// chooses it: {"destination": "SWAN_projects/Project (2)"}
+// The output of the script is the result of the job, or its error.
+// If done is not nil it is called with the job when it succeeds. The input, if
+// not nil, is passed to the script on stdin, as in runShareScriptWithInput.
+func (j *Jobs) start(logger *zap.Logger, cboxShareScript, kind, username, destination string, args []string, input io.Reader, done func(job *Job)) (*Job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info(fmt.Sprintf("job %s cmd args %s", id, args))
+
+	cmd := exec.Command(cboxShareScript, args...)
+	// The job runs in its own process group so that cancelling it also stops the copies started by the script.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdin = input
+	outBuf := &bytes.Buffer{}
+	cmd.Stdout = outBuf
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	job := &Job{ID: id, Kind: kind, Owner: username, State: JobRunning, Destination: destination, Created: time.Now().UTC(), cmd: cmd}
+	j.mu.Lock()
+	j.prune()
+	j.jobs[id] = job
+	j.mu.Unlock()
+
+	go func() {
+		errBuf := &bytes.Buffer{}
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			// Only this goroutine modifies the job until the command exits.
+			line := jobLine{JobProgress: job.Progress, Destination: job.Destination}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				errBuf.Write(scanner.Bytes())
+				errBuf.WriteByte('\n')
+				continue
+			}
+			j.mu.Lock()
+			job.Progress = line.JobProgress
+			job.Destination = line.Destination
+			j.mu.Unlock()
+		}
+		// Drain what is left if a line was too long, for the script not to block.
+		io.Copy(ioutil.Discard, stderr)
+		err := cmd.Wait()
+
+		j.mu.Lock()
+		finished := time.Now().UTC()
+		job.Finished = &finished
+		switch {
+		case job.cancelled:
+			job.State = JobCancelled
+			logger.Info(fmt.Sprintf("Job %s cancelled", id))
+		case err != nil:
+			logger.Error(fmt.Sprintf("Error calling cmd %s %s %s: '%s'", cmd.Path, cmd.Args, err, errBuf.String()))
+			cmderr := CmdError{Statuscode: http.StatusInternalServerError}
+			json.Unmarshal(outBuf.Bytes(), &cmderr)
+			job.State = JobFailed
+			job.Error = cmderr.Error
+			job.Statuscode = cmderr.Statuscode
+		default:
+			job.State = JobSucceeded
+			var result map[string]interface{}
+			if json.Unmarshal(outBuf.Bytes(), &result) == nil {
+				job.Result = outBuf.Bytes()
+				if destination, ok := result["destination"].(string); ok {
+					job.Destination = destination
+				}
+			}
+		}
+		succeeded := job.State == JobSucceeded
+		j.mu.Unlock()
+
+		if succeeded && done != nil {
+			done(j.snapshot(job))
+		}
+	}()
+
+	return job, nil
+}
+
+// writeJob writes the job, with the given status code.
+func writeJob(w http.ResponseWriter, statusCode int, job *Job) {
+	encoded, _ := json.Marshal(job)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(encoded)
+}
+
+// startJob starts a job of the share script and replies Accepted with the job and its location.
+func startJob(logger *zap.Logger, w http.ResponseWriter, jobs *Jobs, cboxShareScript, kind, username, destination string, args []string, done func(job *Job)) {
+	startJobWithInput(logger, w, jobs, cboxShareScript, kind, username, destination, args, nil, done)
+}
+
+// startJobWithInput is like startJob, passing input to the script on stdin.
+func startJobWithInput(logger *zap.Logger, w http.ResponseWriter, jobs *Jobs, cboxShareScript, kind, username, destination string, args []string, input io.Reader, done func(job *Job)) {
+	job, err := jobs.start(logger, cboxShareScript, kind, username, destination, args, input, done)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error starting %s job: %s", kind, err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/swanapi/v1/jobs/"+job.ID)
+	writeJob(w, http.StatusAccepted, jobs.snapshot(job))
+}
+
+// ListJobs lists the jobs of the user.
+func ListJobs(logger *zap.Logger, jobs *Jobs) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		encoded, _ := json.Marshal(map[string]interface{}{"jobs": jobs.list(username)})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
+
+// GetJob returns the state and progress of a job of the user.
+func GetJob(logger *zap.Logger, jobs *Jobs) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		job := jobs.get(username, mux.Vars(r)["id"])
+		if job == nil {
+			writeError(w, http.StatusNotFound, "job not found")
+			return
+		}
+		writeJob(w, http.StatusOK, job)
+	})
+}
+
+// CancelJob cancels a running job of the user. The share script, and the processes
+// it started, are sent SIGTERM and the script is expected to remove what it has copied.
+func CancelJob(logger *zap.Logger, jobs *Jobs) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		id := mux.Vars(r)["id"]
+		if !jobs.cancel(logger, username, id) {
+			writeError(w, http.StatusNotFound, "job not found")
+			return
+		}
+		writeJob(w, http.StatusOK, jobs.get(username, id))
+	})
+}
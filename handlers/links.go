@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// linkPasswordHeader carries the password of a password-protected link.
+const linkPasswordHeader = "X-Link-Password"
+
+// linkTokenRegexp matches the tokens of the links, which are passed to the share
+// script as arguments and so cannot start with a '-'.
+var linkTokenRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+
+// linkToken returns the token of the link in the URL, or writes Bad Request if it is invalid.
+func linkToken(logger *zap.Logger, w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := mux.Vars(r)["token"]
+	if !linkTokenRegexp.MatchString(token) {
+		logger.Error(fmt.Sprintf("Invalid link token %q", token))
+		writeError(w, http.StatusBadRequest, "invalid link token")
+		return "", false
+	}
+	return token, true
+}
+
+const (
+	// maxLinkPasswordFailures is the number of wrong passwords accepted for a link
+	// within linkPasswordWindow, after which the link answers Too Many Requests.
+	maxLinkPasswordFailures = 5
+	linkPasswordWindow      = 15 * time.Minute
+)
+
+// passwordFailures counts the wrong passwords given for each link, to slow down
+// the guessing of the passwords.
+type passwordFailures struct {
+	mu       sync.Mutex
+	failures map[string]*linkFailures
+}
+
+type linkFailures struct {
+	count int
+	since time.Time
+}
+
+// linkPasswordFailures counts the wrong passwords of all the links.
+var linkPasswordFailures = &passwordFailures{failures: map[string]*linkFailures{}}
+
+// blocked tells whether the link had too many wrong passwords in the current window.
+func (p *passwordFailures) blocked(token string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.failures[token]
+	return ok && f.count >= maxLinkPasswordFailures && now.Sub(f.since) < linkPasswordWindow
+}
+
+// record counts a wrong password for the link, and forgets the windows which are over.
+func (p *passwordFailures) record(token string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for t, f := range p.failures {
+		if now.Sub(f.since) >= linkPasswordWindow {
+			delete(p.failures, t)
+		}
+	}
+	f, ok := p.failures[token]
+	if !ok {
+		f = &linkFailures{since: now}
+		p.failures[token] = f
+	}
+	f.count++
+}
+
+// checkLinkPassword runs the share script with the password of the request, if
+// any, unless the link had too many wrong passwords, and counts the wrong ones,
+// which the share script rejects with 401 or 403.
+func checkLinkPassword(logger *zap.Logger, w http.ResponseWriter, r *http.Request, token string, run func(args []string, input io.Reader) ([]byte, int)) ([]byte, int, bool) {
+	if linkPasswordFailures.blocked(token, time.Now()) {
+		logger.Error(fmt.Sprintf("Too many wrong passwords for link %s", token))
+		w.Header().Set("Retry-After", strconv.Itoa(int(linkPasswordWindow.Seconds())))
+		writeError(w, http.StatusTooManyRequests, "too many wrong passwords, retry later")
+		return nil, 0, false
+	}
+	passwordArgs, input := passwordInput(r)
+	jsonResponse, statusCode := run(passwordArgs, input)
+	if input != nil && (statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden) {
+		linkPasswordFailures.record(token, time.Now())
+	}
+	return jsonResponse, statusCode, true
+}
+
+// linkRequest is the optional body of a link creation.
+type linkRequest struct {
+	Password string     `json:"password"`
+	Expires  *time.Time `json:"expires"`
+}
+
+// addLinkURL adds to a link returned by the share script the URL opening it,
+// built from the linkURL template where {token} is replaced by the link token.
+func addLinkURL(linkURL string, link map[string]interface{}) {
+	token, _ := link["token"].(string)
+	if token != "" {
+		link["url"] = strings.Replace(linkURL, "{token}", url.PathEscape(token), -1)
+	}
+}
+
+// writeLinkResponse writes the output of the share script adding the URL of
+// the link, or of each link of a listing.
+func writeLinkResponse(w http.ResponseWriter, linkURL string, jsonResponse []byte, statusCode int) {
+	if statusCode == http.StatusOK {
+		var out map[string]interface{}
+		if err := json.Unmarshal(jsonResponse, &out); err == nil {
+			if links, ok := out["links"].([]interface{}); ok {
+				for _, l := range links {
+					if link, ok := l.(map[string]interface{}); ok {
+						addLinkURL(linkURL, link)
+					}
+				}
+			} else {
+				addLinkURL(linkURL, out)
+			}
+			jsonResponse, _ = json.Marshal(out)
+		}
+	}
+	w.WriteHeader(statusCode)
+	w.Write(jsonResponse)
+}
+
+// CreateLink creates a public read-only link to a project of the user, optionally
+// protected by a password and with an expiration date.
+func CreateLink(logger *zap.Logger, cboxShareScript, linkURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		project := r.URL.Query().Get("project")
+		if project == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: project not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if !checkShareRoot(logger, w, r, project) {
+			return
+		}
+
+		var req linkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			logger.Error(fmt.Sprintf("Cannot unmarshal JSON request body"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		args := []string{"--json", "create-link", username, path.Clean(project)}
+
+		if req.Expires != nil {
+			if !req.Expires.After(time.Now()) {
+				logger.Error(fmt.Sprintf("Link expiration date is in the past"))
+				writeError(w, http.StatusBadRequest, "expiration date is in the past")
+				return
+			}
+			args = append(args, "--expires", strconv.FormatInt(req.Expires.Unix(), 10))
+		}
+
+		var input io.Reader
+		if req.Password != "" {
+			args = append(args, "--password-stdin")
+			input = strings.NewReader(req.Password)
+		}
+
+		jsonResponse, statusCode := runShareScriptWithInput(logger, cboxShareScript, args, input)
+		writeLinkResponse(w, linkURL, jsonResponse, statusCode)
+	})
+}
+
+// ListLinks lists the links of the user, optionally for a single project.
+func ListLinks(logger *zap.Logger, cboxShareScript, linkURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		args := []string{"--json", "list-links"}
+
+		if project := r.URL.Query().Get("project"); project != "" {
+			if !checkShareRoot(logger, w, r, project) {
+				return
+			}
+			args = append(args, "--project", path.Clean(project))
+		}
+
+		args = append(args, username)
+
+		jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+		writeLinkResponse(w, linkURL, jsonResponse, statusCode)
+	})
+}
+
+// DeleteLink revokes a link of the user.
+func DeleteLink(logger *zap.Logger, cboxShareScript string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		token, ok := linkToken(logger, w, r)
+		if !ok {
+			return
+		}
+
+		args := []string{"--json", "delete-link", username, token}
+
+		jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+		w.WriteHeader(statusCode)
+		w.Write(jsonResponse)
+	})
+}
+
+// passwordInput returns the arguments and input passing the link password of the request to the share script.
+func passwordInput(r *http.Request) ([]string, io.Reader) {
+	if password := r.Header.Get(linkPasswordHeader); password != "" {
+		return []string{"--password-stdin"}, strings.NewReader(password)
+	}
+	return nil, nil
+}
+
+// ResolveLink returns the project a link points to, used by the read-only viewer.
+// It is public: the share script checks the password, given in the X-Link-Password
+// header, and the expiration of the link.
+func ResolveLink(logger *zap.Logger, cboxShareScript, linkURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		token, ok := linkToken(logger, w, r)
+		if !ok {
+			return
+		}
+
+		jsonResponse, statusCode, ok := checkLinkPassword(logger, w, r, token, func(passwordArgs []string, input io.Reader) ([]byte, int) {
+			args := append([]string{"--json", "resolve-link", token}, passwordArgs...)
+			return runShareScriptWithInput(logger, cboxShareScript, args, input)
+		})
+		if !ok {
+			return
+		}
+		writeLinkResponse(w, linkURL, jsonResponse, statusCode)
+	})
+}
+
+// CloneLink starts a job cloning the project a link points to into the CERNBox of
+// the user. The link and its password are checked with resolve-link first, for the
+// wrong passwords to be counted and returned before the job starts.
+func CloneLink(logger *zap.Logger, cboxShareScript string, jobs *Jobs) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		token, ok := linkToken(logger, w, r)
+		if !ok {
+			return
+		}
+
+		destination := r.URL.Query().Get("destination")
+		if destination == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: new name of the cloned project not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if !checkShareRoot(logger, w, r, destination) {
+			return
+		}
+
+		jsonResponse, statusCode, ok := checkLinkPassword(logger, w, r, token, func(passwordArgs []string, input io.Reader) ([]byte, int) {
+			args := append([]string{"--json", "resolve-link", token}, passwordArgs...)
+			return runShareScriptWithInput(logger, cboxShareScript, args, input)
+		})
+		if !ok {
+			return
+		}
+		if statusCode != http.StatusOK {
+			w.WriteHeader(statusCode)
+			w.Write(jsonResponse)
+			return
+		}
+
+		passwordArgs, input := passwordInput(r)
+		args := append([]string{"--json", "clone-link", "--progress", token, username, path.Clean(destination)}, passwordArgs...)
+
+		startJobWithInput(logger, w, jobs, cboxShareScript, "clone", username, destination, args, input, nil)
+	})
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func TestResolveLinkChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "share-script")
+	// The share script rejects every password.
+	if err := ioutil.WriteFile(script, []byte("#!/bin/bash\necho '{\"error\":\"wrong password\",\"statuscode\":403}'\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	handler := ResolveLink(zap.NewNop(), script, "https://swan.cern.ch/link/{token}")
+	resolve := func(token, password string) int {
+		r := httptest.NewRequest("GET", "/swanapi/v1/public/x", nil)
+		r = mux.SetURLVars(r, map[string]string{"token": token})
+		if password != "" {
+			r.Header.Set(linkPasswordHeader, password)
+		}
+		context.Set(r, "origin", &OriginSettings{})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		context.Clear(r)
+		return rec.Code
+	}
+
+	for _, token := range []string{"", "-h", "--password-stdin", "a/b", "a b", "a:b"} {
+		if code := resolve(token, ""); code != http.StatusBadRequest {
+			t.Errorf("%q: got status %d, want %d", token, code, http.StatusBadRequest)
+		}
+	}
+
+	for i := 0; i < maxLinkPasswordFailures; i++ {
+		if code := resolve("t0k3n", "guess"); code != http.StatusForbidden {
+			t.Fatalf("attempt %d: got status %d, want %d", i, code, http.StatusForbidden)
+		}
+	}
+	if code := resolve("t0k3n", "guess"); code != http.StatusTooManyRequests {
+		t.Errorf("got status %d after %d wrong passwords, want %d", code, maxLinkPasswordFailures, http.StatusTooManyRequests)
+	}
+	if code := resolve("0th3r", "guess"); code != http.StatusForbidden {
+		t.Errorf("other link: got status %d, want %d", code, http.StatusForbidden)
+	}
+}
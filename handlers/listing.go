@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Listing is a share listing of the share script. The shares are kept as
+// generic JSON objects so that the fields the daemon does not know about are
+// returned untouched.
+type Listing struct {
+	Shares []map[string]interface{}
+	// Fields are the other top level fields of the listing.
+	Fields map[string]interface{}
+}
+
+// ListingFilter filters or annotates a listing before it is returned to the user.
+type ListingFilter func(r *http.Request, username string, listing *Listing) error
+
+// listingRequestError is returned by a listing filter when the request is invalid.
+type listingRequestError struct {
+	msg string
+}
+
+func (e *listingRequestError) Error() string {
+	return e.msg
+}
+
+// parseListing parses a listing of the share script.
+func parseListing(raw []byte) (*Listing, error) {
+	listing := &Listing{}
+	if err := json.Unmarshal(raw, &listing.Fields); err != nil {
+		return nil, err
+	}
+	shares, ok := listing.Fields["shares"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not a share listing")
+	}
+	delete(listing.Fields, "shares")
+	listing.Shares = []map[string]interface{}{}
+	for _, s := range shares {
+		share, ok := s.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("not a share listing")
+		}
+		listing.Shares = append(listing.Shares, share)
+	}
+	return listing, nil
+}
+
+// MarshalJSON encodes the listing as returned by the share script.
+func (l *Listing) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{}
+	for k, v := range l.Fields {
+		out[k] = v
+	}
+	out["shares"] = l.Shares
+	return json.Marshal(out)
+}
+
+// applyListingFilters applies the filters to a listing of the share script.
+func applyListingFilters(r *http.Request, username string, raw []byte, filters []ListingFilter) ([]byte, error) {
+	listing, err := parseListing(raw)
+	if err != nil {
+		return nil, err
+	}
+	for _, filter := range filters {
+		if err := filter(r, username, listing); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(listing)
+}
+
+// shareKey identifies a project shared with the user in the daemon store.
+func shareKey(share map[string]interface{}) string {
+	sharer, _ := share["shared_by"].(string)
+	project, _ := share["project"].(string)
+	return sharer + ":" + project
+}
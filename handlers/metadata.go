@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// ProjectMetadata is the description and the tags the owner of a project has set.
+type ProjectMetadata struct {
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+	Updated     time.Time `json:"updated"`
+}
+
+// Limits of the project metadata.
+const (
+	maxDescriptionLength = 2000
+	maxTags              = 20
+	maxTagLength         = 50
+	maxSummaryLength     = 300
+)
+
+// readmeFile is the file of a project whose first paragraph is its summary.
+const readmeFile = "README.md"
+
+// ProjectsMetadata keeps the metadata of the projects of each owner, by path of the project.
+type ProjectsMetadata struct {
+	store Store
+}
+
+const metadataBucket = "metadata"
+
+// NewProjectsMetadata returns the project metadata kept in the store.
+func NewProjectsMetadata(store Store) *ProjectsMetadata {
+	return &ProjectsMetadata{store: store}
+}
+
+func (m *ProjectsMetadata) get(owner string) (map[string]*ProjectMetadata, error) {
+	metadata := map[string]*ProjectMetadata{}
+	if _, err := m.store.Get(metadataBucket, owner, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// set stores the metadata of a project of the owner, an empty metadata removes it.
+func (m *ProjectsMetadata) set(owner, project string, pm *ProjectMetadata) error {
+	metadata := map[string]*ProjectMetadata{}
+	return updateUserData(m.store, metadataBucket, owner, &metadata, func() error {
+		if pm.Description == "" && len(pm.Tags) == 0 {
+			delete(metadata, path.Clean(project))
+		} else {
+			metadata[path.Clean(project)] = pm
+		}
+		return nil
+	})
+}
+
+// transfer moves the metadata of a project of the owner to the recipient, who
+// owns the project once the share script has moved it to their home under the same path.
+func (m *ProjectsMetadata) transfer(owner, project, recipient string) error {
+	metadata, err := m.get(owner)
+	if err != nil {
+		return err
+	}
+	pm, ok := metadata[path.Clean(project)]
+	if !ok {
+		return nil
+	}
+	if err := m.set(recipient, project, pm); err != nil {
+		return err
+	}
+	return m.set(owner, project, &ProjectMetadata{})
+}
+
+// Filter is a listing filter setting the description and tags fields of the
+// projects that have them, and keeping only the projects with all the tags given
+// in the tag query parameters. With owned, the projects are the ones of the user,
+// otherwise the ones shared with them.
+func (m *ProjectsMetadata) Filter(owned bool) ListingFilter {
+	return func(r *http.Request, username string, listing *Listing) error {
+		owners := map[string]map[string]*ProjectMetadata{}
+		tags := r.URL.Query()["tag"]
+		shares := []map[string]interface{}{}
+		for _, share := range listing.Shares {
+			owner := username
+			if !owned {
+				owner, _ = share["shared_by"].(string)
+			}
+			metadata, ok := owners[owner]
+			if !ok {
+				var err error
+				if metadata, err = m.get(owner); err != nil {
+					return err
+				}
+				owners[owner] = metadata
+			}
+			project, _ := share["project"].(string)
+			pm, ok := metadata[path.Clean(project)]
+			if ok {
+				share["description"] = pm.Description
+				share["tags"] = pm.Tags
+			}
+			matches := true
+			for _, tag := range tags {
+				if !ok || !stringInSlice(normalizeTag(tag), pm.Tags) {
+					matches = false
+				}
+			}
+			if matches {
+				shares = append(shares, share)
+			}
+		}
+		listing.Shares = shares
+		return nil
+	}
+}
+
+// normalizeTag returns the tag trimmed and in lower case, so that the tags match regardless of their case.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// metadataRequest is the body of PUT /share/metadata.
+type metadataRequest struct {
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+// metadata validates the request and returns the metadata to store.
+func (req *metadataRequest) metadata() (*ProjectMetadata, error) {
+	pm := &ProjectMetadata{Description: strings.TrimSpace(req.Description), Tags: []string{}, Updated: time.Now().UTC()}
+	if utf8.RuneCountInString(pm.Description) > maxDescriptionLength {
+		return nil, fmt.Errorf("the description is longer than %d characters", maxDescriptionLength)
+	}
+	for _, tag := range req.Tags {
+		tag = normalizeTag(tag)
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength || strings.IndexFunc(tag, func(c rune) bool {
+			return unicode.IsSpace(c) || unicode.IsControl(c) || c == ','
+		}) >= 0 {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		if !stringInSlice(tag, pm.Tags) {
+			pm.Tags = append(pm.Tags, tag)
+		}
+	}
+	if len(pm.Tags) > maxTags {
+		return nil, fmt.Errorf("more than %d tags", maxTags)
+	}
+	return pm, nil
+}
+
+// readmeSummary returns the first paragraph of a README, leaving out the headings,
+// images and HTML, shortened to maxSummaryLength characters.
+func readmeSummary(readme string) string {
+	paragraph := []string{}
+	for _, line := range strings.Split(readme, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if len(paragraph) > 0 {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "![") || strings.HasPrefix(line, "[![") || strings.HasPrefix(line, "<") {
+			if len(paragraph) > 0 {
+				break
+			}
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	summary := strings.Join(paragraph, " ")
+	if utf8.RuneCountInString(summary) > maxSummaryLength {
+		summary = strings.TrimSpace(string([]rune(summary)[:maxSummaryLength-1])) + "…"
+	}
+	return summary
+}
+
+// GetProjectMetadata returns the description and tags of a project of the user
+// or, with the sharer query parameter, of a project shared with them, and the
+// summary of its README.
+func GetProjectMetadata(logger *zap.Logger, cboxShareScript string, m *ProjectsMetadata, readmeMaxSize int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		project := r.URL.Query().Get("project")
+		if project == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: project not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		owner := username
+		if sharer := r.URL.Query().Get("sharer"); sharer != "" {
+			if checkSharedWith(logger, w, cboxShareScript, username, sharer, project) == nil {
+				return
+			}
+			owner = sharer
+		} else if !checkShareRoot(logger, w, r, project) {
+			return
+		}
+
+		metadata, err := m.get(owner)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error loading project metadata: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		pm, ok := metadata[path.Clean(project)]
+		if !ok {
+			pm = &ProjectMetadata{Tags: []string{}}
+		}
+
+		out := map[string]interface{}{
+			"project":     project,
+			"owner":       owner,
+			"description": pm.Description,
+			"tags":        pm.Tags,
+		}
+		if !pm.Updated.IsZero() {
+			out["updated"] = pm.Updated
+		}
+		// The owner reads the README of their own project as its sharer.
+		if readme, err := readShareFile(logger, cboxShareScript, readmeMaxSize, username, owner, project, readmeFile); err == nil {
+			if summary := readmeSummary(string(readme)); summary != "" {
+				out["summary"] = summary
+			}
+		}
+
+		encoded, _ := json.Marshal(out)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
+
+// SetProjectMetadata sets the description and tags of a project of the user.
+func SetProjectMetadata(logger *zap.Logger, m *ProjectsMetadata) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		project := r.URL.Query().Get("project")
+		if project == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: project not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !checkShareRoot(logger, w, r, project) {
+			return
+		}
+
+		var req metadataRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Error(fmt.Sprintf("Cannot unmarshal JSON request body"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		pm, err := req.metadata()
+		if err != nil {
+			logger.Error(fmt.Sprintf("Invalid project metadata: %s", err))
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := m.set(username, project, pm); err != nil {
+			logger.Error(fmt.Sprintf("Error storing project metadata: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		encoded, _ := json.Marshal(map[string]interface{}{
+			"project":     project,
+			"owner":       username,
+			"description": pm.Description,
+			"tags":        pm.Tags,
+			"updated":     pm.Updated,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import "testing"
+
+func TestProjectsMetadataTransfer(t *testing.T) {
+	store, _, cleanup := guestStore(t)
+	defer cleanup()
+	m := NewProjectsMetadata(store)
+	if err := m.set("alice", "SWAN_projects/A/", &ProjectMetadata{Description: "An analysis", Tags: []string{"physics"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.set("alice", "SWAN_projects/B/", &ProjectMetadata{Description: "Another one"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.transfer("alice", "SWAN_projects/A", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := m.get("bob")
+	if pm := bob["SWAN_projects/A"]; pm == nil || pm.Description != "An analysis" || len(pm.Tags) != 1 {
+		t.Errorf("unexpected metadata of the recipient %v", bob)
+	}
+	alice, _ := m.get("alice")
+	if _, ok := alice["SWAN_projects/A"]; ok || len(alice) != 1 {
+		t.Errorf("unexpected metadata of the owner after the transfer %v", alice)
+	}
+	if err := m.transfer("alice", "SWAN_projects/C", "bob"); err != nil {
+		t.Errorf("transfer of a project without metadata: %s", err)
+	}
+}
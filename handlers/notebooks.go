@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// NotebookInfo is the metadata of a notebook of a project.
+type NotebookInfo struct {
+	Path     string `json:"path"`
+	Kernel   string `json:"kernel,omitempty"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+// ProjectNotebooks is the metadata of the notebooks of a project.
+type ProjectNotebooks struct {
+	Count     int             `json:"count"`
+	Kernels   []string        `json:"kernels"`
+	Languages []string        `json:"languages"`
+	Release   string          `json:"release,omitempty"` // LCG release of the SWAN software stack
+	Files     []*NotebookInfo `json:"files"`
+}
+
+// swanProjectFile is the file where SWAN keeps the settings of a project.
+const swanProjectFile = ".swanproject"
+
+// notebookCacheSize is the maximum number of projects and files whose metadata is cached.
+const notebookCacheSize = 10000
+
+type cachedFile struct {
+	modified string
+	value    interface{}
+}
+
+// Notebooks extracts the metadata of the notebooks of the projects shared with
+// the users, reading them with the share script. The metadata of each project
+// and of each file is cached until its modification time changes.
+type Notebooks struct {
+	logger          *zap.Logger
+	cboxShareScript string
+	maxSize         int64
+
+	mu    sync.Mutex
+	cache map[string]*cachedFile
+}
+
+// NewNotebooks returns a notebook metadata extractor. Notebooks larger than maxSize bytes are not read.
+func NewNotebooks(logger *zap.Logger, cboxShareScript string, maxSize int64) *Notebooks {
+	return &Notebooks{logger: logger, cboxShareScript: cboxShareScript, maxSize: maxSize, cache: map[string]*cachedFile{}}
+}
+
+// cached returns the cached value for the file, computing it with read if the file was modified.
+func (n *Notebooks) cached(key, modified string, read func() (interface{}, error)) (interface{}, error) {
+	n.mu.Lock()
+	c, ok := n.cache[key]
+	n.mu.Unlock()
+	if ok && modified != "" && c.modified == modified {
+		return c.value, nil
+	}
+	value, err := read()
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	if len(n.cache) >= notebookCacheSize {
+		// Make room by dropping arbitrary entries.
+		for k := range n.cache {
+			delete(n.cache, k)
+			if len(n.cache) < notebookCacheSize*9/10 {
+				break
+			}
+		}
+	}
+	n.cache[key] = &cachedFile{modified: modified, value: value}
+	n.mu.Unlock()
+	return value, nil
+}
+
+// readShareFile returns the content of a file of a project shared with the user,
+// read with the share script. Files larger than maxSize bytes are refused.
+func readShareFile(logger *zap.Logger, cboxShareScript string, maxSize int64, username, sharer, project, file string) ([]byte, error) {
+	args := []string{"--json", "read-share-file", "--max-size", strconv.FormatInt(maxSize, 10), sharer, path.Clean(project), username, path.Clean(file)}
+	jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot read %s: status %d", file, statusCode)
+	}
+	var out struct {
+		Encoding string `json:"encoding"`
+		Content  string `json:"content"`
+	}
+	if err := json.Unmarshal(jsonResponse, &out); err != nil {
+		return nil, err
+	}
+	if out.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(out.Content)
+	}
+	return []byte(out.Content), nil
+}
+
+// notebook is the part of a notebook the metadata is extracted from.
+type notebook struct {
+	Metadata struct {
+		Kernelspec struct {
+			Name     string `json:"name"`
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+	Cells []struct {
+		CellType string          `json:"cell_type"`
+		Source   json.RawMessage `json:"source"`
+	} `json:"cells"`
+}
+
+// parseNotebook extracts the metadata of a notebook.
+func parseNotebook(file string, content []byte) (*NotebookInfo, error) {
+	var nb notebook
+	if err := json.Unmarshal(content, &nb); err != nil {
+		return nil, err
+	}
+	info := &NotebookInfo{Path: file, Kernel: nb.Metadata.Kernelspec.Name, Language: nb.Metadata.Kernelspec.Language}
+	if info.Language == "" {
+		info.Language = nb.Metadata.LanguageInfo.Name
+	}
+	for _, cell := range nb.Cells {
+		if cell.CellType != "markdown" {
+			continue
+		}
+		// The source is a string or a list of lines.
+		var source string
+		var lines []string
+		if json.Unmarshal(cell.Source, &lines) == nil {
+			source = strings.Join(lines, "")
+		} else {
+			json.Unmarshal(cell.Source, &source)
+		}
+		for _, line := range strings.Split(source, "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "#") {
+				info.Title = strings.TrimSpace(strings.TrimLeft(line, "#"))
+				return info, nil
+			}
+		}
+	}
+	return info, nil
+}
+
+// project returns the metadata of the notebooks of a project shared with the
+// user, cached until the modification time of the project changes. The key ends
+// with a slash not to collide with the keys of the files.
+func (n *Notebooks) project(username, sharer, project, modified string) (*ProjectNotebooks, error) {
+	value, err := n.cached(sharer+":"+path.Clean(project)+"/", modified, func() (interface{}, error) {
+		return n.readProject(username, sharer, project)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*ProjectNotebooks), nil
+}
+
+// readProject lists the files of a project shared with the user and extracts the metadata of its notebooks.
+func (n *Notebooks) readProject(username, sharer, project string) (*ProjectNotebooks, error) {
+	args := []string{"--json", "list-share-tree", sharer, path.Clean(project), username}
+	jsonResponse, statusCode := runShareScript(n.logger, n.cboxShareScript, args)
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot list the files: status %d", statusCode)
+	}
+	var tree struct {
+		Entries []struct {
+			Path     string      `json:"path"`
+			Type     string      `json:"type"`
+			Size     json.Number `json:"size"`
+			Modified string      `json:"modified"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(jsonResponse, &tree); err != nil {
+		return nil, err
+	}
+
+	result := &ProjectNotebooks{Kernels: []string{}, Languages: []string{}, Files: []*NotebookInfo{}}
+	for _, entry := range tree.Entries {
+		if entry.Type == TypeDirectory {
+			continue
+		}
+		key := sharer + ":" + path.Clean(project) + ":" + entry.Path
+		if entry.Path == swanProjectFile {
+			value, err := n.cached(key, entry.Modified, func() (interface{}, error) {
+				content, err := readShareFile(n.logger, n.cboxShareScript, n.maxSize, username, sharer, project, entry.Path)
+				if err != nil {
+					return nil, err
+				}
+				var settings struct {
+					Release string `json:"release"`
+				}
+				json.Unmarshal(content, &settings)
+				return settings.Release, nil
+			})
+			if err != nil {
+				n.logger.Error(fmt.Sprintf("Error reading %s of project '%s' of %s: %s", entry.Path, project, sharer, err))
+				continue
+			}
+			result.Release = value.(string)
+			continue
+		}
+		if path.Ext(entry.Path) != ".ipynb" || strings.Contains(entry.Path, ".ipynb_checkpoints/") {
+			continue
+		}
+		result.Count++
+		if size, err := entry.Size.Int64(); err == nil && size > n.maxSize {
+			result.Files = append(result.Files, &NotebookInfo{Path: entry.Path})
+			continue
+		}
+		value, err := n.cached(key, entry.Modified, func() (interface{}, error) {
+			content, err := readShareFile(n.logger, n.cboxShareScript, n.maxSize, username, sharer, project, entry.Path)
+			if err != nil {
+				return nil, err
+			}
+			return parseNotebook(entry.Path, content)
+		})
+		if err != nil {
+			n.logger.Error(fmt.Sprintf("Error reading notebook %s of project '%s' of %s: %s", entry.Path, project, sharer, err))
+			result.Files = append(result.Files, &NotebookInfo{Path: entry.Path})
+			continue
+		}
+		info := value.(*NotebookInfo)
+		result.Files = append(result.Files, info)
+		if info.Kernel != "" && !stringInSlice(info.Kernel, result.Kernels) {
+			result.Kernels = append(result.Kernels, info.Kernel)
+		}
+		if info.Language != "" && !stringInSlice(info.Language, result.Languages) {
+			result.Languages = append(result.Languages, info.Language)
+		}
+	}
+	sort.Strings(result.Kernels)
+	sort.Strings(result.Languages)
+	return result, nil
+}
+
+// notebookWorkers is the number of projects whose notebooks are read in parallel.
+const notebookWorkers = 4
+
+// Filter is the listing filter of /shared that, with the details=notebooks query
+// parameter, sets the notebooks field of each share. It comes after the
+// pagination so that only the returned shares are read.
+func (n *Notebooks) Filter() ListingFilter {
+	return func(r *http.Request, username string, listing *Listing) error {
+		if r.URL.Query().Get("details") != "notebooks" {
+			return nil
+		}
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, notebookWorkers)
+		for _, share := range listing.Shares {
+			sharer, _ := share["shared_by"].(string)
+			project, _ := share["project"].(string)
+			modified, _ := share["modified"].(string)
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(share map[string]interface{}) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				notebooks, err := n.project(username, sharer, project, modified)
+				if err != nil {
+					n.logger.Error(fmt.Sprintf("Error reading the notebooks of project '%s' of %s: %s", project, sharer, err))
+					return
+				}
+				share["notebooks"] = notebooks
+			}(share)
+		}
+		wg.Wait()
+		return nil
+	}
+}
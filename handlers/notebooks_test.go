@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestNotebooksFilterCachesProjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notebooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "share-script")
+	err = ioutil.WriteFile(script, []byte(`#!/bin/bash
+echo "$2" >> `+calls+`
+case "$2" in
+list-share-tree)
+	echo '{"entries":[{"path":"analysis.ipynb","type":"file","size":120,"modified":"2020-01-01T00:00:00Z"}]}';;
+read-share-file)
+	echo '{"path":"analysis.ipynb","encoding":"utf-8","content":"{\"metadata\":{\"kernelspec\":{\"name\":\"python3\",\"language\":\"python\"}},\"cells\":[]}"}';;
+esac
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNotebooks(zap.NewNop(), script, 1024)
+	list := func(modified string) *ProjectNotebooks {
+		r := httptest.NewRequest("GET", "/swanapi/v1/shared?details=notebooks", nil)
+		listing := &Listing{Shares: []map[string]interface{}{{"project": "SWAN_projects/A/", "shared_by": "alice", "modified": modified}}}
+		if err := n.Filter()(r, "bob", listing); err != nil {
+			t.Fatal(err)
+		}
+		notebooks, _ := listing.Shares[0]["notebooks"].(*ProjectNotebooks)
+		if notebooks == nil || notebooks.Count != 1 || len(notebooks.Kernels) != 1 || notebooks.Kernels[0] != "python3" {
+			t.Fatalf("unexpected notebooks %+v", notebooks)
+		}
+		return notebooks
+	}
+	count := func() int {
+		out, _ := ioutil.ReadFile(calls)
+		return strings.Count(string(out), "list-share-tree")
+	}
+
+	list("2020-01-01T00:00:00Z")
+	list("2020-01-01T00:00:00Z")
+	if c := count(); c != 1 {
+		t.Errorf("project listed %d times, want once while it is not modified", c)
+	}
+	list("2020-02-01T00:00:00Z")
+	if c := count(); c != 2 {
+		t.Errorf("project listed %d times, want twice after it is modified", c)
+	}
+}
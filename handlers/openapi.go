@@ -0,0 +1,768 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Schema is the subset of the OpenAPI 3 schema object used to describe and
+// validate the API.
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	MinLength   int                `json:"minLength,omitempty"`
+	MinItems    int                `json:"minItems,omitempty"`
+	Minimum     *int64             `json:"minimum,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+}
+
+// Param is a query or path parameter of a route.
+type Param struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// QueryParam returns a string query parameter.
+func QueryParam(name, description string, required bool) *Param {
+	return &Param{Name: name, In: "query", Description: description, Required: required, Schema: &Schema{Type: "string"}}
+}
+
+// EnumParam returns a query parameter taking one of values.
+func EnumParam(name, description string, required bool, values ...string) *Param {
+	return &Param{Name: name, In: "query", Description: description, Required: required, Schema: &Schema{Type: "string", Enum: values}}
+}
+
+// IntParam returns an optional integer query parameter not lower than minimum.
+func IntParam(name, description string, minimum int64) *Param {
+	return &Param{Name: name, In: "query", Description: description, Schema: &Schema{Type: "integer", Minimum: &minimum}}
+}
+
+// DateTimeParam returns an optional RFC 3339 date query parameter.
+func DateTimeParam(name, description string) *Param {
+	return &Param{Name: name, In: "query", Description: description, Schema: &Schema{Type: "string", Format: "date-time"}}
+}
+
+// PathParam returns a string path parameter.
+func PathParam(name, description string) *Param {
+	return &Param{Name: name, In: "path", Description: description, Required: true, Schema: &Schema{Type: "string"}}
+}
+
+// HeaderParam returns an optional string header parameter.
+func HeaderParam(name, description string) *Param {
+	return &Param{Name: name, In: "header", Description: description, Schema: &Schema{Type: "string"}}
+}
+
+// Ref returns a reference to a schema of the API components.
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// Schemas are the components of the API referenced by the routes.
+var Schemas = map[string]*Schema{
+	"Error": {
+		Type: "object",
+		Properties: map[string]*Schema{
+			"error":      {Type: "string"},
+			"statuscode": {Type: "integer"},
+		},
+	},
+	"Token": {
+		Type:     "object",
+		Required: []string{"authtoken", "expire"},
+		Properties: map[string]*Schema{
+			"authtoken": {Type: "string"},
+			"expire":    {Type: "string", Format: "date-time"},
+		},
+	},
+	"Sharee": {
+		Type:     "object",
+		Required: []string{"name", "entity"},
+		Properties: map[string]*Schema{
+			"name":        {Type: "string", MinLength: 1, Description: "name of the user or group, or email address of the guest"},
+			"entity":      {Type: "string", Enum: shareEntities, Description: "u for user accounts, egroup for egroups, g for unix groups, email for guests outside CERN"},
+			"permissions": {Type: "string", Enum: sharePermissions, Description: "r (default) for read only, rw for read-write, rw+reshare to also allow resharing"},
+			"expires":     {Type: "string", Format: "date-time", Description: "the share is removed after this time"},
+		},
+	},
+	"ShareRequest": {
+		Type:     "object",
+		Required: []string{"share_with"},
+		Properties: map[string]*Schema{
+			"share_with": {Type: "array", MinItems: 1, Items: Ref("Sharee")},
+		},
+	},
+	"PatchShareRequest": {
+		Type: "object",
+		Properties: map[string]*Schema{
+			"add":    {Type: "array", Items: Ref("Sharee"), Description: "sharees to add or whose permissions change"},
+			"remove": {Type: "array", Items: Ref("Sharee"), Description: "sharees to remove, the permissions are ignored"},
+		},
+	},
+	"ShareeInfo": {
+		Type: "object",
+		Properties: map[string]*Schema{
+			"name":         {Type: "string"},
+			"entity":       {Type: "string"},
+			"display_name": {Type: "string"},
+			"permissions":  {Type: "string", Enum: sharePermissions},
+			"created":      {Type: "string"},
+			"expires":      {Type: "string", Format: "date-time"},
+			"status":       {Type: "string", Description: "pending for the guests invited by email who have not logged in yet"},
+		},
+	},
+	"Share": {
+		Type:     "object",
+		Required: []string{"project", "path"},
+		Properties: map[string]*Schema{
+			"project":     {Type: "string"},
+			"path":        {Type: "string", Description: "path of the project in the storage, or relative to the home of the owner for a project shared only with guests"},
+			"type":        {Type: "string", Enum: shareTypes, Description: "whether the shared path is a directory or a single file"},
+			"shared_by":   {Type: "string"},
+			"modified":    {Type: "string", Description: "last modification time"},
+			"size":        {Description: "size in bytes"},
+			"inode":       {Description: "inode of the project directory"},
+			"shared_with": {Type: "array", Items: Ref("ShareeInfo")},
+			"status":      {Type: "string", Enum: invitationStatuses, Description: "invitation status of a project shared with the user"},
+			"hidden":      {Type: "boolean", Description: "whether the user has hidden a project shared with them"},
+			"cloned_from": Ref("CloneOrigin"),
+			"notebooks":   Ref("ProjectNotebooks"),
+			"description": {Type: "string", Description: "description set by the owner of the project"},
+			"tags":        {Type: "array", Items: &Schema{Type: "string"}, Description: "tags set by the owner of the project"},
+			"starred":     {Type: "boolean", Description: "whether the user has starred the project"},
+		},
+	},
+	"InvitationRequest": {
+		Type:     "object",
+		Required: []string{"status"},
+		Properties: map[string]*Schema{
+			"status": {Type: "string", Enum: invitationStatuses},
+		},
+	},
+	"ShareList": {
+		Type:     "object",
+		Required: []string{"shares"},
+		Properties: map[string]*Schema{
+			"shares":      {Type: "array", Items: Ref("Share")},
+			"total":       {Type: "integer", Description: "number of shares matching the filters"},
+			"next_cursor": {Type: "string", Description: "cursor of the next page, if any"},
+		},
+	},
+	"Tree": {
+		Type:     "object",
+		Required: []string{"entries"},
+		Properties: map[string]*Schema{
+			"entries": {
+				Type: "array",
+				Items: &Schema{
+					Type:     "object",
+					Required: []string{"path", "type"},
+					Properties: map[string]*Schema{
+						"path":     {Type: "string", Description: "path relative to the project"},
+						"type":     {Type: "string", Enum: shareTypes},
+						"size":     {Type: "integer", Description: "size in bytes"},
+						"modified": {Type: "string", Format: "date-time"},
+					},
+				},
+			},
+		},
+	},
+	"File": {
+		Type:     "object",
+		Required: []string{"path", "content"},
+		Properties: map[string]*Schema{
+			"path":     {Type: "string", Description: "path relative to the project"},
+			"size":     {Type: "integer", Description: "size in bytes"},
+			"modified": {Type: "string", Format: "date-time"},
+			"encoding": {Type: "string", Enum: []string{"utf-8", "base64"}, Description: "utf-8 for text files, base64 for the others"},
+			"content":  {Type: "string"},
+		},
+	},
+	"Transfer": {
+		Type:     "object",
+		Required: []string{"id", "owner", "project", "recipient"},
+		Properties: map[string]*Schema{
+			"id":          {Type: "string"},
+			"owner":       {Type: "string"},
+			"project":     {Type: "string"},
+			"recipient":   {Type: "string"},
+			"proposed_by": {Type: "string"},
+			"created":     {Type: "string", Format: "date-time"},
+		},
+	},
+	"TransferList": {
+		Type:     "object",
+		Required: []string{"transfers"},
+		Properties: map[string]*Schema{
+			"transfers": {Type: "array", Items: Ref("Transfer")},
+		},
+	},
+	"ProjectNotebooks": {
+		Type:        "object",
+		Description: "metadata of the notebooks of a project, with details=notebooks",
+		Required:    []string{"count", "kernels", "languages", "files"},
+		Properties: map[string]*Schema{
+			"count":     {Type: "integer"},
+			"kernels":   {Type: "array", Items: &Schema{Type: "string"}},
+			"languages": {Type: "array", Items: &Schema{Type: "string"}},
+			"release":   {Type: "string", Description: "LCG release of the SWAN software stack of the project"},
+			"files":     {Type: "array", Items: Ref("NotebookInfo")},
+		},
+	},
+	"NotebookInfo": {
+		Type:     "object",
+		Required: []string{"path"},
+		Properties: map[string]*Schema{
+			"path":     {Type: "string"},
+			"kernel":   {Type: "string"},
+			"language": {Type: "string"},
+			"title":    {Type: "string", Description: "first markdown heading of the notebook"},
+		},
+	},
+	"CloneOrigin": {
+		Type:     "object",
+		Required: []string{"sharer", "project"},
+		Properties: map[string]*Schema{
+			"sharer":  {Type: "string"},
+			"project": {Type: "string"},
+			"version": {Type: "string", Description: "version of the original project the clone is up to date with"},
+			"cloned":  {Type: "string", Format: "date-time"},
+		},
+	},
+	"CloneList": {
+		Type:     "object",
+		Required: []string{"clones"},
+		Properties: map[string]*Schema{
+			"clones": {
+				Type: "array",
+				Items: &Schema{
+					Type:     "object",
+					Required: []string{"project", "cloned_from"},
+					Properties: map[string]*Schema{
+						"project":     {Type: "string"},
+						"cloned_from": Ref("CloneOrigin"),
+					},
+				},
+			},
+		},
+	},
+	"ChangeList": {
+		Type:     "object",
+		Required: []string{"changes"},
+		Properties: map[string]*Schema{
+			"changes": {
+				Type: "array",
+				Items: &Schema{
+					Type:     "object",
+					Required: []string{"path", "change"},
+					Properties: map[string]*Schema{
+						"path":   {Type: "string", Description: "path relative to the project"},
+						"change": {Type: "string", Enum: []string{"added", "modified", "deleted"}},
+					},
+				},
+			},
+		},
+	},
+	"ProjectMetadata": {
+		Type:     "object",
+		Required: []string{"project", "owner", "description", "tags"},
+		Properties: map[string]*Schema{
+			"project":     {Type: "string"},
+			"owner":       {Type: "string"},
+			"description": {Type: "string"},
+			"tags":        {Type: "array", Items: &Schema{Type: "string"}},
+			"updated":     {Type: "string", Format: "date-time"},
+			"summary":     {Type: "string", Description: "first paragraph of the README.md of the project"},
+		},
+	},
+	"StarredProject": {
+		Type:     "object",
+		Required: []string{"owner", "project", "starred"},
+		Properties: map[string]*Schema{
+			"owner":   {Type: "string"},
+			"project": {Type: "string"},
+			"starred": {Type: "string", Format: "date-time"},
+		},
+	},
+	"StarredList": {
+		Type:     "object",
+		Required: []string{"starred"},
+		Properties: map[string]*Schema{
+			"starred": {Type: "array", Items: Ref("StarredProject")},
+		},
+	},
+	"MetadataRequest": {
+		Type: "object",
+		Properties: map[string]*Schema{
+			"description": {Type: "string", Description: "at most 2000 characters"},
+			"tags":        {Type: "array", Items: &Schema{Type: "string", MinLength: 1}, Description: "at most 20 tags, without spaces or commas, stored in lower case"},
+		},
+	},
+	"PullRequest": {
+		Type: "object",
+		Properties: map[string]*Schema{
+			"files": {Type: "array", Items: &Schema{Type: "string", MinLength: 1}, Description: "paths, relative to the project, of the files to update, all the changed files if empty"},
+		},
+	},
+	"Job": {
+		Type:     "object",
+		Required: []string{"id", "kind", "state"},
+		Properties: map[string]*Schema{
+			"id":    {Type: "string"},
+			"kind":  {Type: "string"},
+			"owner": {Type: "string"},
+			"state": {Type: "string", Enum: jobStates},
+			"progress": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"bytes":       {Type: "integer", Description: "bytes copied"},
+					"files":       {Type: "integer", Description: "files copied"},
+					"total_bytes": {Type: "integer"},
+					"total_files": {Type: "integer"},
+				},
+			},
+			"destination": {Type: "string", Description: "path written by the job, as chosen by the share script"},
+			"result":      {Type: "object", Description: "output of the share script, when the job succeeded"},
+			"error":       {Type: "string", Description: "error of the share script, when the job failed"},
+			"statuscode":  {Type: "integer", Description: "status code of the error"},
+			"created":     {Type: "string", Format: "date-time"},
+			"finished":    {Type: "string", Format: "date-time"},
+		},
+	},
+	"JobList": {
+		Type:     "object",
+		Required: []string{"jobs"},
+		Properties: map[string]*Schema{
+			"jobs": {Type: "array", Items: Ref("Job")},
+		},
+	},
+	"CloneRequest": {
+		Type: "object",
+		Properties: map[string]*Schema{
+			"include": {Type: "array", Items: &Schema{Type: "string", MinLength: 1}, Description: "glob patterns or paths, relative to the project, of the files to clone"},
+			"exclude": {Type: "array", Items: &Schema{Type: "string", MinLength: 1}, Description: "glob patterns or paths, relative to the project, of the files not to clone"},
+		},
+	},
+	"LinkRequest": {
+		Type: "object",
+		Properties: map[string]*Schema{
+			"password": {Type: "string", Description: "password protecting the link, empty for none"},
+			"expires":  {Type: "string", Format: "date-time", Description: "the link stops working after this time"},
+		},
+	},
+	"Link": {
+		Type:     "object",
+		Required: []string{"token"},
+		Properties: map[string]*Schema{
+			"token":              {Type: "string"},
+			"url":                {Type: "string", Description: "URL opening the project in the read-only viewer"},
+			"project":            {Type: "string"},
+			"shared_by":          {Type: "string"},
+			"path":               {Type: "string"},
+			"size":               {Description: "size in bytes"},
+			"created":            {Type: "string"},
+			"expires":            {Type: "string", Format: "date-time"},
+			"password_protected": {Type: "boolean"},
+		},
+	},
+	"LinkList": {
+		Type:     "object",
+		Required: []string{"links"},
+		Properties: map[string]*Schema{
+			"links": {Type: "array", Items: Ref("Link")},
+		},
+	},
+	"Account": {
+		Type: "object",
+		Properties: map[string]*Schema{
+			"account_type": {Type: "string", Enum: []string{"primary", "secondary", "service", "egroup", "unixgroup"}},
+			"cn":           {Type: "string"},
+			"display_name": {Type: "string"},
+			"dn":           {Type: "string"},
+			"mail":         {Type: "string"},
+		},
+	},
+	"AccountList": {
+		Type:  "array",
+		Items: Ref("Account"),
+	},
+}
+
+// OpenAPI builds the OpenAPI 3 document of the routes.
+func OpenAPI(routes []*Route, version string) map[string]interface{} {
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		operation := map[string]interface{}{
+			"summary":   route.Description,
+			"responses": openAPIResponses(route),
+		}
+		if len(route.Params) > 0 {
+			operation["parameters"] = route.Params
+		}
+		if route.Body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": !route.OptionalBody,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": route.Body}},
+			}
+		}
+		switch route.Auth {
+		case AuthJWT, AuthOIDC, AuthGuestOIDC:
+			operation["security"] = []map[string][]string{{string(route.Auth): {}}}
+		}
+		if len(route.Scopes) > 0 {
+			operation["x-scopes"] = route.Scopes
+		}
+		if paths[route.Path] == nil {
+			paths[route.Path] = map[string]interface{}{}
+		}
+		paths[route.Path][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "SWAN API Daemon for CERNBox",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": Schemas,
+			"securitySchemes": map[string]interface{}{
+				string(AuthJWT):       map[string]string{"type": "http", "scheme": "bearer", "description": "token minted by /swanapi/v1/authenticate or /swanapi/v2/authenticate"},
+				string(AuthOIDC):      map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT", "description": "OIDC token issued by the SSO"},
+				string(AuthGuestOIDC): map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT", "description": "OIDC token issued by the external accounts realm of the SSO"},
+			},
+		},
+	}
+}
+
+func openAPIResponses(route *Route) map[string]interface{} {
+	errorContent := map[string]interface{}{"application/json": map[string]interface{}{"schema": Ref("Error")}}
+	ok := map[string]interface{}{"description": http.StatusText(route.successStatus())}
+	if route.Response != nil {
+		ok["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": route.Response}}
+	}
+	responses := map[string]interface{}{
+		"400": map[string]interface{}{"description": "Bad Request", "content": errorContent},
+	}
+	responses[strconv.Itoa(route.successStatus())] = ok
+	if route.Auth == AuthJWT || route.Auth == AuthOIDC || route.Auth == AuthGuestOIDC {
+		responses["401"] = map[string]interface{}{"description": "Unauthorized"}
+	}
+	if len(route.Scopes) > 0 {
+		responses["403"] = map[string]interface{}{"description": "Forbidden"}
+	}
+	for _, p := range route.Params {
+		if p.In == "header" && p.Name == "If-Match" {
+			responses["412"] = map[string]interface{}{"description": "Precondition Failed", "content": errorContent}
+		}
+	}
+	if route.Auth == AuthJWT {
+		responses["500"] = map[string]interface{}{"description": "Internal Server Error", "content": errorContent}
+	}
+	return responses
+}
+
+// OpenAPIDoc serves the OpenAPI document of the routes.
+func OpenAPIDoc(logger *zap.Logger, routes *[]*Route, version string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoded, err := json.MarshalIndent(OpenAPI(*routes, version), "", "  ")
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error encoding OpenAPI document: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
+
+// maxBodySize is the maximum size of the JSON request bodies.
+const maxBodySize = 1 << 20
+
+// writeError writes a JSON error reply.
+func writeError(w http.ResponseWriter, statusCode int, msg string) {
+	encoded, _ := json.Marshal(&CmdError{Error: msg, Statuscode: statusCode})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(encoded)
+}
+
+// ValidateRequest rejects with Bad Request the requests whose query parameters or
+// body do not conform to the route description, including unknown query
+// parameters and body properties.
+func ValidateRequest(logger *zap.Logger, route *Route, handler http.Handler) http.Handler {
+	known := map[string]bool{}
+	for _, p := range route.Params {
+		if p.In == "query" {
+			known[p.Name] = true
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		query := r.URL.Query()
+		names := make([]string, 0, len(query))
+		for name := range query {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !known[name] {
+				logger.Error(fmt.Sprintf("Unknown query parameter '%s'", name))
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown query parameter %s", name))
+				return
+			}
+		}
+		for _, p := range route.Params {
+			if p.In != "query" {
+				continue
+			}
+			values, ok := query[p.Name]
+			if !ok {
+				if p.Required {
+					logger.Error(fmt.Sprintf("Missing query parameter '%s'", p.Name))
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("missing query parameter %s", p.Name))
+					return
+				}
+				continue
+			}
+			for _, v := range values {
+				if err := validateParam(p.Schema, v); err != nil {
+					logger.Error(fmt.Sprintf("Invalid query parameter '%s': %s", p.Name, err))
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid query parameter %s: %s", p.Name, err))
+					return
+				}
+			}
+		}
+
+		if route.Body != nil {
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			if err != nil {
+				logger.Error(fmt.Sprintf("Error reading request body: %s", err))
+				writeError(w, http.StatusBadRequest, "cannot read request body")
+				return
+			}
+			if route.OptionalBody && len(bytes.TrimSpace(body)) == 0 {
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+				handler.ServeHTTP(w, r)
+				return
+			}
+			var value interface{}
+			if err := json.Unmarshal(body, &value); err != nil {
+				logger.Error(fmt.Sprintf("Request body is not JSON: %s", err))
+				writeError(w, http.StatusBadRequest, "request body is not valid JSON")
+				return
+			}
+			if err := validateValue(route.Body, value, "body", true); err != nil {
+				logger.Error(fmt.Sprintf("Invalid request body: %s", err))
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// responseRecorder keeps a copy of the response to check it once written.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// CheckResponse logs a warning when a successful response does not conform
+// to the route description. It is meant to spot contract drifts between the
+// daemon, the share script and the document in test deployments. The routes
+// without a JSON response, such as the streamed archives, are left untouched.
+func CheckResponse(logger *zap.Logger, route *Route, handler http.Handler) http.Handler {
+	if route.Response == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		handler.ServeHTTP(rec, r)
+
+		if rec.statusCode != route.successStatus() {
+			return
+		}
+		if err := ValidateResponse(route, rec.body.Bytes()); err != nil {
+			logger.Warn(fmt.Sprintf("Response of %s %s does not conform to the API: %s", route.Method, route.Path, err))
+		}
+	})
+}
+
+// ValidateResponse checks a successful response body against the response
+// schema of the route.
+func ValidateResponse(route *Route, body []byte) error {
+	if route.Response == nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("response is not JSON: %s", err)
+	}
+	return validateValue(route.Response, value, "response", false)
+}
+
+func resolve(schema *Schema) *Schema {
+	for schema.Ref != "" {
+		s, ok := Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			panic(fmt.Errorf("unknown schema %s", schema.Ref))
+		}
+		schema = s
+	}
+	return schema
+}
+
+func validateParam(schema *Schema, v string) error {
+	schema = resolve(schema)
+	switch schema.Type {
+	case "integer":
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		if schema.Minimum != nil && i < *schema.Minimum {
+			return fmt.Errorf("%q is lower than %d", v, *schema.Minimum)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("%q is not a boolean", v)
+		}
+	case "string":
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				return fmt.Errorf("%q is not a RFC 3339 date", v)
+			}
+		}
+	}
+	if len(schema.Enum) > 0 && !stringInSlice(v, schema.Enum) {
+		return fmt.Errorf("%q is not one of %s", v, strings.Join(schema.Enum, ", "))
+	}
+	if len(v) < schema.MinLength {
+		return fmt.Errorf("%q is too short", v)
+	}
+	return nil
+}
+
+// validateValue checks a decoded JSON value against the schema.
+// validateValue checks a JSON value against the schema. With strict, the objects
+// must not have properties the schema does not describe: the requests are strict,
+// while the responses can carry more fields than documented.
+func validateValue(schema *Schema, value interface{}, where string, strict bool) error {
+	schema = resolve(schema)
+	switch schema.Type {
+	case "":
+		return nil
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", where)
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s.%s is required", where, name)
+			}
+		}
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if strict && len(schema.Properties) > 0 {
+			keys := make([]string, 0, len(obj))
+			for name := range obj {
+				keys = append(keys, name)
+			}
+			sort.Strings(keys)
+			for _, name := range keys {
+				if _, ok := schema.Properties[name]; !ok {
+					return fmt.Errorf("%s.%s is not a known property", where, name)
+				}
+			}
+		}
+		for _, name := range names {
+			if v, ok := obj[name]; ok && v != nil {
+				if err := validateValue(schema.Properties[name], v, where+"."+name, strict); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", where)
+		}
+		if len(list) < schema.MinItems {
+			return fmt.Errorf("%s must have at least %d elements", where, schema.MinItems)
+		}
+		if schema.Items != nil {
+			for i, v := range list {
+				if err := validateValue(schema.Items, v, fmt.Sprintf("%s[%d]", where, i), strict); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", where)
+		}
+		if len(s) < schema.MinLength {
+			return fmt.Errorf("%s must have at least %d characters", where, schema.MinLength)
+		}
+		if len(schema.Enum) > 0 && !stringInSlice(s, schema.Enum) {
+			return fmt.Errorf("%s must be one of %s", where, strings.Join(schema.Enum, ", "))
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fmt.Errorf("%s must be a RFC 3339 date", where)
+			}
+		}
+	case "integer", "number":
+		f, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("%s must be a number", where)
+		}
+		if schema.Type == "integer" && f != float64(int64(f)) {
+			return fmt.Errorf("%s must be an integer", where)
+		}
+		if schema.Minimum != nil && f < float64(*schema.Minimum) {
+			return fmt.Errorf("%s must be at least %d", where, *schema.Minimum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", where)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestValidateRequest(t *testing.T) {
+	route := &Route{
+		Path: "/swanapi/v1/share", Method: "PUT",
+		Params: []*Param{QueryParam("project", "path of the project", true)},
+		Body:   Ref("ShareRequest"),
+	}
+	handler := ValidateRequest(zap.NewNop(), route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, c := range []struct {
+		query  string
+		body   string
+		status int
+	}{
+		{"project=A", `{"share_with":[{"name":"alice","entity":"u"}]}`, http.StatusOK},
+		{"", `{"share_with":[{"name":"alice","entity":"u"}]}`, http.StatusBadRequest},
+		{"project=A&owner=bob", `{"share_with":[{"name":"alice","entity":"u"}]}`, http.StatusBadRequest},
+		{"project=A", `{"share_with":[{"name":"alice","entity":"u"}],"notify":true}`, http.StatusBadRequest},
+		{"project=A", `{"share_with":[{"name":"alice","entity":"u","role":"admin"}]}`, http.StatusBadRequest},
+		{"project=A", `{"share_with":[{"name":"alice","entity":"x"}]}`, http.StatusBadRequest},
+		{"project=A", `{"share_with":[]}`, http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest("PUT", "/swanapi/v1/share?"+c.query, strings.NewReader(c.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != c.status {
+			t.Errorf("%q %s: got status %d, want %d", c.query, c.body, rec.Code, c.status)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// OriginSettings are the settings applied to the requests coming from an
+// allowed origin. Each SWAN deployment can override the defaults.
+type OriginSettings struct {
+	// TokenLifetime is the validity of the tokens minted for the origin, in seconds.
+	TokenLifetime int `json:"token_lifetime"`
+	// Scopes are the API scopes the origin is allowed to use. Empty means all.
+	Scopes []string `json:"scopes"`
+	// ShareRoot is the directory, relative to the user home, where the projects
+	// of the origin live. Empty means no restriction.
+	ShareRoot string `json:"share_root"`
+}
+
+// API scopes that can be granted to an origin.
+const (
+	ScopeRead   = "read"   // list shares
+	ScopeShare  = "share"  // create, modify and delete shares
+	ScopeClone  = "clone"  // clone shared projects
+	ScopeSearch = "search" // search the directory
+)
+
+// originOverride is an entry of the origins configuration file.
+type originOverride struct {
+	Origin string `json:"origin"`
+	OriginSettings
+}
+
+type originRule struct {
+	pattern  string
+	host     *regexp.Regexp
+	port     string // "" for the default port, "*" for any port
+	settings *OriginSettings
+}
+
+// Origins is the list of origins allowed to use the API.
+type Origins struct {
+	rules []*originRule
+}
+
+// NewOrigins compiles the allowed origin patterns. A pattern is a host name,
+// optionally with a port, where '*' matches any sequence of characters inside
+// a single label: "swan.cern.ch", "swan*.cern.ch", "*.cern.ch:8443", "swan.cern.ch:*".
+// Matching is case insensitive and anchored to the whole host.
+// If overridesFile is not empty it is read as a JSON list of origins with their
+// own settings; unset fields are taken from defaults.
+func NewOrigins(patterns []string, defaults OriginSettings, overridesFile string) (*Origins, error) {
+	o := &Origins{}
+
+	if overridesFile != "" {
+		data, err := ioutil.ReadFile(overridesFile)
+		if err != nil {
+			return nil, err
+		}
+		var overrides []*originOverride
+		if err := json.Unmarshal(data, &overrides); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %s", overridesFile, err)
+		}
+		for _, ov := range overrides {
+			settings := ov.OriginSettings
+			if settings.TokenLifetime == 0 {
+				settings.TokenLifetime = defaults.TokenLifetime
+			}
+			if settings.Scopes == nil {
+				settings.Scopes = defaults.Scopes
+			}
+			if settings.ShareRoot == "" {
+				settings.ShareRoot = defaults.ShareRoot
+			}
+			if err := o.add(ov.Origin, &settings); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, p := range patterns {
+		settings := defaults
+		if err := o.add(p, &settings); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// legacyWildcard is the wildcard of the regular expressions allowfrom used to take.
+const legacyWildcard = "[a-z0-9-]*"
+
+func (o *Origins) add(pattern string, settings *OriginSettings) error {
+	p := strings.ToLower(strings.TrimSpace(pattern))
+	p = strings.TrimPrefix(p, "https://")
+	if p == "" || strings.Contains(p, "/") {
+		return fmt.Errorf("invalid origin pattern: %q", pattern)
+	}
+	// The patterns used to be regular expressions. The wildcard of the old
+	// default, swan[a-z0-9-]*.cern.ch, is what '*' matches now, and the others
+	// would silently never match.
+	p = strings.Replace(p, legacyWildcard, "*", -1)
+	p = strings.Replace(p, `\.`, ".", -1)
+	if strings.ContainsAny(p, `[](){}\|+?^$`) {
+		return fmt.Errorf("invalid origin pattern: %q: allowfrom no longer takes regular expressions, rewrite it with '*' matching any characters inside a label (e.g. swan*.cern.ch for swan[a-z0-9-]*.cern.ch)", pattern)
+	}
+
+	host, port := p, ""
+	if i := strings.LastIndex(p, ":"); i >= 0 {
+		host, port = p[:i], p[i+1:]
+		if port == "443" {
+			port = ""
+		}
+	}
+
+	var labels []string
+	for _, label := range strings.Split(host, ".") {
+		if label == "" {
+			return fmt.Errorf("invalid origin pattern: %q", pattern)
+		}
+		parts := strings.Split(label, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		labels = append(labels, strings.Join(parts, "[a-z0-9-]*"))
+	}
+	re, err := regexp.Compile("^" + strings.Join(labels, `\.`) + "$")
+	if err != nil {
+		return fmt.Errorf("invalid origin pattern: %q: %s", pattern, err)
+	}
+
+	o.rules = append(o.rules, &originRule{pattern: pattern, host: re, port: port, settings: settings})
+	return nil
+}
+
+// Match returns the settings of the first pattern matching the origin.
+func (o *Origins) Match(origin *url.URL) (*OriginSettings, bool) {
+	if origin.Scheme != "https" {
+		return nil, false
+	}
+	host := strings.ToLower(origin.Hostname())
+	port := origin.Port()
+	if port == "443" {
+		port = ""
+	}
+	for _, rule := range o.rules {
+		if rule.port != "*" && rule.port != port {
+			continue
+		}
+		if rule.host.MatchString(host) {
+			return rule.settings, true
+		}
+	}
+	return nil, false
+}
+
+// String returns the configured patterns, used in log messages.
+func (o *Origins) String() string {
+	var patterns []string
+	for _, rule := range o.rules {
+		patterns = append(patterns, rule.pattern)
+	}
+	return strings.Join(patterns, ",")
+}
+
+// Allows returns true if the scope is granted by the settings.
+func (s *OriginSettings) Allows(scope string) bool {
+	return len(s.Scopes) == 0 || stringInSlice(scope, s.Scopes)
+}
+
+// InShareRoot returns true if the project path lies inside the share root.
+func (s *OriginSettings) InShareRoot(project string) bool {
+	if s.ShareRoot == "" {
+		return true
+	}
+	root := path.Clean("/" + s.ShareRoot)
+	p := path.Clean("/" + project)
+	return strings.HasPrefix(p, root+"/")
+}
+
+// originSettings returns the settings stored in the request context by CORSProcessOriginHeader.
+func originSettings(r *http.Request) *OriginSettings {
+	if s, ok := context.Get(r, "origin").(*OriginSettings); ok {
+		return s
+	}
+	return &OriginSettings{}
+}
+
+// validHomePath returns true if p is a path relative to the user home that does not leave it.
+// The path can be a project, any other folder or a single file. It must not start with
+// '-', not to be taken for an option by the share script, which is given path.Clean(p).
+func validHomePath(p string) bool {
+	if p == "" || strings.HasPrefix(p, "/") || strings.IndexFunc(p, unicode.IsControl) >= 0 {
+		return false
+	}
+	clean := path.Clean(p)
+	return clean != "." && clean != ".." && !strings.HasPrefix(clean, "../") && !strings.HasPrefix(clean, "-")
+}
+
+// checkShareRoot writes Bad Request and returns false if the project is not a valid path
+// inside the user home or is outside the share root of the origin.
+func checkShareRoot(logger *zap.Logger, w http.ResponseWriter, r *http.Request, project string) bool {
+	if !validHomePath(project) {
+		logger.Error(fmt.Sprintf("Invalid path '%s'", project))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid path %q", project))
+		return false
+	}
+	settings := originSettings(r)
+	if !settings.InShareRoot(project) {
+		logger.Error(fmt.Sprintf("Project '%s' is outside the share root '%s'", project, settings.ShareRoot))
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// CheckScope rejects with Forbidden the requests whose origin is not granted the
+// scope, and the requests of the guests for any scope but read.
+func CheckScope(logger *zap.Logger, scope string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !originSettings(r).Allows(scope) {
+			logger.Error(fmt.Sprintf("Origin '%s' is not allowed to use scope '%s'", r.Header.Get("Origin"), scope))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if guest, _ := context.Get(r, "guest").(bool); guest && scope != ScopeRead {
+			logger.Error(fmt.Sprintf("Guest %v is not allowed to use scope '%s'", context.Get(r, "username"), scope))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
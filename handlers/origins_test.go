@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestOriginsMatch(t *testing.T) {
+	origins, err := NewOrigins([]string{"swan*.cern.ch", "*.cern.ch:8443", "swan-dev.cern.ch:*"}, OriginSettings{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		origin string
+		match  bool
+	}{
+		{"https://swan005.cern.ch", true},
+		{"https://swan.cern.ch", true},
+		{"https://SWAN005.CERN.CH", true},
+		{"https://swan005.cern.ch:443", true},
+		{"http://swan005.cern.ch", false},
+		{"https://swan005.cern.ch:8080", false},
+		{"https://a.swan.cern.ch", false},
+		{"https://swan.cern.ch.evil.com", false},
+		{"https://x.cern.ch:8443", true},
+		{"https://swan-dev.cern.ch:1234", true},
+	} {
+		u, _ := url.Parse(c.origin)
+		if _, ok := origins.Match(u); ok != c.match {
+			t.Errorf("%s: got match %t, want %t", c.origin, ok, c.match)
+		}
+	}
+}
+
+func TestOriginsRejectRegexps(t *testing.T) {
+	for _, pattern := range []string{"swan[0-9]*.cern.ch", "swan.+.cern.ch", "^swan.cern.ch$", "(swan|swan-dev).cern.ch", "https://swan.cern.ch/", ""} {
+		if _, err := NewOrigins([]string{pattern}, OriginSettings{}, ""); err == nil {
+			t.Errorf("%q: expected an error", pattern)
+		}
+	}
+}
+
+func TestValidHomePath(t *testing.T) {
+	for _, c := range []struct {
+		path  string
+		valid bool
+	}{
+		{"SWAN_projects/Project 1/", true},
+		{"analysis.ipynb", true},
+		{"SWAN_projects/-draft", true},
+		{"./SWAN_projects/../data", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../alice", false},
+		{"SWAN_projects/../../alice", false},
+		{"/eos/user/b/bob", false},
+		{"-rf", false},
+		{"--project=x", false},
+		{"./-rf", false},
+		{"SWAN_projects/\n", false},
+	} {
+		if valid := validHomePath(c.path); valid != c.valid {
+			t.Errorf("%q: got %t, want %t", c.path, valid, c.valid)
+		}
+	}
+}
+
+func TestOriginsLegacyDefault(t *testing.T) {
+	origins, err := NewOrigins([]string{"swan[a-z0-9-]*.cern.ch", `swan-dev\.cern\.ch`}, OriginSettings{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, origin := range []string{"https://swan005.cern.ch", "https://swan-dev.cern.ch"} {
+		u, _ := url.Parse(origin)
+		if _, ok := origins.Match(u); !ok {
+			t.Errorf("%s: no match", origin)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sort keys of the share listings.
+const (
+	SortName    = "name"
+	SortDate    = "date"
+	SortSize    = "size"
+	SortSharer  = "sharer"
+	SortStarred = "starred"
+)
+
+// SortKeys are the accepted values of the sort query parameter.
+var SortKeys = []string{SortName, SortDate, SortSize, SortSharer, SortStarred}
+
+// shareDateLayouts are the formats of the dates of the share script listings.
+var shareDateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05"}
+
+func parseShareDate(v interface{}) (time.Time, bool) {
+	s, _ := v.(string)
+	for _, layout := range shareDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// sharees returns the sharees of a share of a listing.
+func sharees(share map[string]interface{}) []map[string]interface{} {
+	var out []map[string]interface{}
+	list, _ := share["shared_with"].([]interface{})
+	for _, s := range list {
+		if sharee, ok := s.(map[string]interface{}); ok {
+			out = append(out, sharee)
+		}
+	}
+	return out
+}
+
+// shareDate is the modification time of a share, or, if the share script does
+// not report it, the creation time of its most recent sharee.
+func shareDate(share map[string]interface{}) (time.Time, bool) {
+	if t, ok := parseShareDate(share["modified"]); ok {
+		return t, true
+	}
+	var latest time.Time
+	found := false
+	for _, sharee := range sharees(share) {
+		if t, ok := parseShareDate(sharee["created"]); ok && (!found || t.After(latest)) {
+			latest, found = t, true
+		}
+	}
+	return latest, found
+}
+
+// shareSize is the size of a share, reported as a number or a string.
+func shareSize(share map[string]interface{}) float64 {
+	switch v := share["size"].(type) {
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// hasSharee tells whether the share has a sharee whose field equals value.
+func hasSharee(share map[string]interface{}, field, value string) bool {
+	for _, sharee := range sharees(share) {
+		if s, _ := sharee[field].(string); s == value {
+			return true
+		}
+	}
+	return false
+}
+
+// shareLess compares two shares by the sort key, then by project and sharer so
+// that the order, and hence the pages, are stable. The starred shares come first
+// when sorting by starred.
+func shareLess(a, b map[string]interface{}, key string) bool {
+	switch key {
+	case SortDate:
+		ta, _ := shareDate(a)
+		tb, _ := shareDate(b)
+		if !ta.Equal(tb) {
+			return ta.Before(tb)
+		}
+	case SortSize:
+		if sa, sb := shareSize(a), shareSize(b); sa != sb {
+			return sa < sb
+		}
+	case SortSharer:
+		sa, _ := a["shared_by"].(string)
+		sb, _ := b["shared_by"].(string)
+		if sa != sb {
+			return sa < sb
+		}
+	case SortStarred:
+		sa, _ := a["starred"].(bool)
+		sb, _ := b["starred"].(bool)
+		if sa != sb {
+			return sa
+		}
+	}
+	pa, _ := a["project"].(string)
+	pb, _ := b["project"].(string)
+	if pa != pb {
+		return pa < pb
+	}
+	return shareKey(a) < shareKey(b)
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, &listingRequestError{"invalid cursor"}
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, &listingRequestError{"invalid cursor"}
+	}
+	return offset, nil
+}
+
+// PageFilter is the last listing filter of /sharing and /shared. It keeps the
+// shares matching the sharer, sharee, entity and modified_since query parameters,
+// sorts them by the sort and order parameters and, if limit is given, returns
+// the page starting at cursor. The total field of the listing is the number of
+// matching shares and next_cursor, if there are more, the cursor of the next page.
+func PageFilter() ListingFilter {
+	return func(r *http.Request, username string, listing *Listing) error {
+		query := r.URL.Query()
+
+		var since time.Time
+		if v := query.Get("modified_since"); v != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, v); err != nil {
+				return &listingRequestError{"invalid modified_since date"}
+			}
+		}
+
+		shares := []map[string]interface{}{}
+		for _, share := range listing.Shares {
+			if sharer := query.Get("sharer"); sharer != "" {
+				if s, _ := share["shared_by"].(string); s != sharer {
+					continue
+				}
+			}
+			if sharee := query.Get("sharee"); sharee != "" && !hasSharee(share, "name", sharee) {
+				continue
+			}
+			if entity := query.Get("entity"); entity != "" && !hasSharee(share, "entity", entity) {
+				continue
+			}
+			if !since.IsZero() {
+				if t, ok := shareDate(share); !ok || t.Before(since) {
+					continue
+				}
+			}
+			shares = append(shares, share)
+		}
+
+		key := query.Get("sort")
+		if key == "" {
+			key = SortName
+		}
+		desc := strings.EqualFold(query.Get("order"), "desc")
+		sort.SliceStable(shares, func(i, j int) bool {
+			if desc {
+				i, j = j, i
+			}
+			return shareLess(shares[i], shares[j], key)
+		})
+
+		if listing.Fields == nil {
+			listing.Fields = map[string]interface{}{}
+		}
+		listing.Fields["total"] = len(shares)
+
+		if v := query.Get("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit < 1 {
+				return &listingRequestError{"invalid limit"}
+			}
+			offset := 0
+			if cursor := query.Get("cursor"); cursor != "" {
+				if offset, err = decodeCursor(cursor); err != nil {
+					return err
+				}
+			}
+			if offset > len(shares) {
+				offset = len(shares)
+			}
+			// The limit is clamped before the addition, which could overflow.
+			if limit > len(shares)-offset {
+				limit = len(shares) - offset
+			}
+			end := offset + limit
+			if end < len(shares) {
+				listing.Fields["next_cursor"] = encodeCursor(end)
+			}
+			shares = shares[offset:end]
+		}
+
+		listing.Shares = shares
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// pagingShares returns a listing of three projects: A shared by alice, the most
+// recently modified and the biggest; B shared by carol with an egroup, the
+// oldest and starred; C shared by alice, whose date is the creation of its sharee.
+func pagingShares() []map[string]interface{} {
+	return []map[string]interface{}{
+		{
+			"project": "SWAN_projects/C/", "shared_by": "alice", "size": 200.0, "starred": false,
+			"shared_with": []interface{}{map[string]interface{}{"name": "dave", "entity": EntityUser, "created": "2020-02-01T00:00:00"}},
+		},
+		{
+			"project": "SWAN_projects/A/", "shared_by": "alice", "size": 300.0, "starred": false, "modified": "2020-03-01T00:00:00Z",
+			"shared_with": []interface{}{map[string]interface{}{"name": "bob", "entity": EntityUser}},
+		},
+		{
+			"project": "SWAN_projects/B/", "shared_by": "carol", "size": "100", "starred": true, "modified": "2020-01-01T00:00:00Z",
+			"shared_with": []interface{}{map[string]interface{}{"name": "it-dep", "entity": EntityEgroup}},
+		},
+	}
+}
+
+func TestPageFilter(t *testing.T) {
+	for _, c := range []struct {
+		query    string
+		projects []string
+		next     string
+		err      bool
+	}{
+		{query: "", projects: []string{"A", "B", "C"}},
+		{query: "order=desc", projects: []string{"C", "B", "A"}},
+		{query: "sort=date", projects: []string{"B", "C", "A"}},
+		{query: "sort=date&order=desc", projects: []string{"A", "C", "B"}},
+		{query: "sort=size", projects: []string{"B", "C", "A"}},
+		{query: "sort=sharer", projects: []string{"A", "C", "B"}},
+		{query: "sort=starred", projects: []string{"B", "A", "C"}},
+		{query: "sharer=alice", projects: []string{"A", "C"}},
+		{query: "sharee=bob", projects: []string{"A"}},
+		{query: "entity=egroup", projects: []string{"B"}},
+		{query: "modified_since=2020-01-15T00:00:00Z", projects: []string{"A", "C"}},
+		{query: "limit=2", projects: []string{"A", "B"}, next: encodeCursor(2)},
+		{query: "limit=2&cursor=" + encodeCursor(2), projects: []string{"C"}},
+		{query: "limit=1&cursor=" + encodeCursor(1), projects: []string{"B"}, next: encodeCursor(2)},
+		{query: "limit=1&cursor=" + encodeCursor(10), projects: []string{}},
+		{query: "limit=9223372036854775807&cursor=MQ", projects: []string{"B", "C"}},
+		{query: "limit=0", err: true},
+		{query: "limit=x", err: true},
+		{query: "limit=1&cursor=!!", err: true},
+		{query: "limit=1&cursor=" + encodeCursor(-1), err: true},
+		{query: "modified_since=yesterday", err: true},
+	} {
+		r := httptest.NewRequest("GET", "/swanapi/v1/shared?"+c.query, nil)
+		listing := &Listing{Shares: pagingShares()}
+		err := PageFilter()(r, "bob", listing)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", c.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", c.query, err)
+			continue
+		}
+		projects := []string{}
+		for _, share := range listing.Shares {
+			project, _ := share["project"].(string)
+			projects = append(projects, project[len("SWAN_projects/"):len(project)-1])
+		}
+		if !reflect.DeepEqual(projects, c.projects) {
+			t.Errorf("%q: got %v, want %v", c.query, projects, c.projects)
+		}
+		if next, _ := listing.Fields["next_cursor"].(string); next != c.next {
+			t.Errorf("%q: got next_cursor %q, want %q", c.query, next, c.next)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	for _, offset := range []int{0, 1, 25, 1000000} {
+		if got, err := decodeCursor(encodeCursor(offset)); err != nil || got != offset {
+			t.Errorf("%d: got %d, %v", offset, got, err)
+		}
+	}
+	for _, cursor := range []string{"", "!!", encodeCursor(-5), "YWJj"} {
+		if _, err := decodeCursor(cursor); err == nil {
+			t.Errorf("%q: expected an error", cursor)
+		}
+	}
+}
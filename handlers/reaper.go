@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// expiredShares is the output of the list-expired-shares command of the share
+// script, and of the list-shared-by command used to check them again.
+type expiredShares struct {
+	Shares []struct {
+		Project    string    `json:"project"`
+		SharedBy   string    `json:"shared_by"`
+		SharedWith []*Sharee `json:"shared_with"`
+	} `json:"shares"`
+}
+
+// StartShareReaper removes the expired shares and guest invitations every interval, in the background.
+func StartShareReaper(logger *zap.Logger, cboxShareScript string, guests *Guests, interval time.Duration) {
+	go func() {
+		for {
+			reapExpiredShares(logger, cboxShareScript, time.Now())
+			if err := guests.removeExpired(time.Now()); err != nil {
+				logger.Error(fmt.Sprintf("Share reaper: cannot remove expired guest invitations: %s", err))
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// reapExpiredShares removes the shares expired at the given time. The backend already
+// denies the access to expired shares, this cleans up the share listings.
+func reapExpiredShares(logger *zap.Logger, cboxShareScript string, now time.Time) {
+
+	args := []string{"--json", "list-expired-shares", "--before", fmt.Sprintf("%d", now.Unix())}
+	jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+	if statusCode != http.StatusOK {
+		logger.Error(fmt.Sprintf("Share reaper: cannot list expired shares: %s", jsonResponse))
+		return
+	}
+
+	var expired expiredShares
+	if err := json.Unmarshal(jsonResponse, &expired); err != nil {
+		logger.Error(fmt.Sprintf("Share reaper: cannot parse expired shares: %s", err))
+		return
+	}
+
+	for _, share := range expired.Shares {
+		for _, sharee := range share.SharedWith {
+			if sharee.Expires == nil || sharee.Expires.After(now) {
+				continue
+			}
+			removeExpiredShare(logger, cboxShareScript, share.SharedBy, share.Project, sharee, now)
+		}
+	}
+}
+
+// removeExpiredShare removes the share of the project with the sharee. The share is
+// listed again under the lock, as the owner may have extended it since the listing
+// of the expired shares.
+func removeExpiredShare(logger *zap.Logger, cboxShareScript, owner, project string, sharee *Sharee, now time.Time) {
+	key := shareLockKey(owner, project)
+	shareLocks.lock(key)
+	defer shareLocks.unlock(key)
+
+	if !shareExpired(logger, cboxShareScript, owner, project, sharee, now) {
+		return
+	}
+
+	args := []string{"--json", "patch-share", owner, path.Clean(project), "--remove", sharee.Entity + ":" + sharee.Name}
+	jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+	if statusCode != http.StatusOK {
+		logger.Error(fmt.Sprintf("Share reaper: cannot remove expired share of project '%s' of %s with %s:%s: %s",
+			project, owner, sharee.Entity, sharee.Name, jsonResponse))
+		return
+	}
+
+	logger.Info("Share reaper: removed expired share",
+		zap.String("owner", owner),
+		zap.String("project", project),
+		zap.String("entity", sharee.Entity),
+		zap.String("sharee", sharee.Name),
+		zap.Time("expires", *sharee.Expires))
+}
+
+// shareExpired tells whether the current share of the project with the sharee expired at the given time.
+func shareExpired(logger *zap.Logger, cboxShareScript, owner, project string, sharee *Sharee, now time.Time) bool {
+	args := []string{"--json", "list-shared-by", "--project", path.Clean(project), owner}
+	jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+	if statusCode != http.StatusOK {
+		logger.Error(fmt.Sprintf("Share reaper: cannot list the shares of project '%s' of %s: %s", project, owner, jsonResponse))
+		return false
+	}
+
+	var current expiredShares
+	if err := json.Unmarshal(jsonResponse, &current); err != nil {
+		logger.Error(fmt.Sprintf("Share reaper: cannot parse the shares of project '%s' of %s: %s", project, owner, err))
+		return false
+	}
+
+	for _, share := range current.Shares {
+		if path.Clean(share.Project) != path.Clean(project) {
+			continue
+		}
+		for _, s := range share.SharedWith {
+			if s.Entity == sharee.Entity && s.Name == sharee.Name {
+				return s.Expires != nil && !s.Expires.After(now)
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestReaperKeepsExtendedShares(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reaper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "share-script")
+	// carol expired in the first listing, but her share was extended before the removal.
+	err = ioutil.WriteFile(script, []byte(`#!/bin/bash
+echo "$@" >> `+calls+`
+case "$2" in
+list-expired-shares)
+	echo '{"shares":[{"project":"SWAN_projects/A/","shared_by":"bob","shared_with":[{"name":"alice","entity":"u","expires":"2020-01-01T00:00:00Z"},{"name":"carol","entity":"u","expires":"2020-01-01T00:00:00Z"}]}]}';;
+list-shared-by)
+	echo '{"shares":[{"project":"SWAN_projects/A/","shared_by":"bob","shared_with":[{"name":"alice","entity":"u","expires":"2020-01-01T00:00:00Z"},{"name":"carol","entity":"u","expires":"2030-01-01T00:00:00Z"}]}]}';;
+*)
+	echo '{}';;
+esac
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reapExpiredShares(zap.NewNop(), script, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	out, _ := ioutil.ReadFile(calls)
+	if !strings.Contains(string(out), "patch-share bob SWAN_projects/A --remove u:alice") {
+		t.Errorf("expired share not removed: %q", out)
+	}
+	if strings.Contains(string(out), "u:carol") {
+		t.Errorf("extended share removed: %q", out)
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// AuthMode is the authentication applied to a route.
+type AuthMode string
+
+const (
+	AuthNone       AuthMode = "none"       // public
+	AuthShibboleth AuthMode = "shibboleth" // identity headers set by shibd
+	AuthOIDC       AuthMode = "oidc"       // OIDC token issued by the SSO
+	AuthGuestOIDC  AuthMode = "guest-oidc" // OIDC token issued by the external accounts realm
+	AuthJWT        AuthMode = "jwt"        // token minted by /authenticate
+)
+
+// Route describes an API endpoint.
+type Route struct {
+	Path         string
+	Method       string
+	Handler      http.Handler
+	Auth         AuthMode
+	Scopes       []string // scopes the origin needs to be granted
+	Description  string
+	Params       []*Param // query parameters
+	Body         *Schema  // JSON request body, nil if none
+	OptionalBody bool     // the request body can be omitted
+	Response     *Schema  // JSON response body, nil if not JSON
+	Status       int      // status code of the successful responses, 200 if zero
+}
+
+// successStatus returns the status code of the successful responses of the route.
+func (route *Route) successStatus() int {
+	if route.Status == 0 {
+		return http.StatusOK
+	}
+	return route.Status
+}
+
+// Authenticators wrap a handler with the authentication of each mode.
+type Authenticators map[AuthMode]func(http.Handler) http.Handler
+
+// RegisterRoutes registers the routes in the router, wrapped by their authentication,
+// scope checks, request validation and the CORS middleware. For each path it also
+// registers the OPTIONS preflight and a Method Not Allowed reply listing the allowed methods.
+// If checkResponses is true the responses not conforming to the routes are logged.
+func RegisterRoutes(logger *zap.Logger, router *mux.Router, routes []*Route, auth Authenticators, origins *Origins, config *CORSConfig, checkResponses bool) {
+	for _, path := range routePaths(routes) {
+		var methods []string
+		for _, route := range routes {
+			if route.Path != path {
+				continue
+			}
+			authenticate, ok := auth[route.Auth]
+			if !ok {
+				panic(fmt.Errorf("no authentication configured for mode %s of %s %s", route.Auth, route.Method, route.Path))
+			}
+			handler := ValidateRequest(logger, route, route.Handler)
+			if checkResponses {
+				handler = CheckResponse(logger, route, handler)
+			}
+			for i := len(route.Scopes) - 1; i >= 0; i-- {
+				handler = CheckScope(logger, route.Scopes[i], handler)
+			}
+			router.Handle(path, CORS(logger, origins, config, authenticate(handler))).Methods(route.Method)
+			methods = append(methods, route.Method)
+		}
+		router.Handle(path, Options(logger, methods, origins, config)).Methods("OPTIONS")
+		router.Handle(path, MethodNotAllowed(logger, append(methods, "OPTIONS")))
+	}
+}
+
+// routePaths returns the distinct paths of the routes, in order of appearance.
+func routePaths(routes []*Route) []string {
+	var paths []string
+	for _, route := range routes {
+		if !stringInSlice(route.Path, paths) {
+			paths = append(paths, route.Path)
+		}
+	}
+	return paths
+}
+
+// MethodNotAllowed replies 405 with the Allow header.
+func MethodNotAllowed(logger *zap.Logger, allowedMethods []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Error(fmt.Sprintf("Method %s not allowed on %s", r.Method, r.URL.Path))
+		w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+}
+
+// RoutesDoc renders the route table as a markdown table.
+func RoutesDoc(routes []*Route) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "| Method | Path | Authentication | Scopes | Description |")
+	fmt.Fprintln(buf, "|--------|------|----------------|--------|-------------|")
+	for _, route := range routes {
+		fmt.Fprintf(buf, "| %s | %s | %s | %s | %s |\n", route.Method, route.Path, route.Auth, strings.Join(route.Scopes, ", "), route.Description)
+	}
+	return buf.String()
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// Share entities.
+const (
+	EntityUser      = "u"
+	EntityEgroup    = "egroup"
+	EntityUnixGroup = "g"
+	EntityEmail     = "email" // guest outside CERN, invited by email
+)
+
+// Share permissions.
+const (
+	PermRead         = "r"
+	PermReadWrite    = "rw"
+	PermReadWriteShr = "rw+reshare"
+)
+
+// Types of the shared paths, as reported by the share script in the listings.
+const (
+	TypeDirectory = "directory"
+	TypeFile      = "file"
+)
+
+// Clone modes when the destination already exists.
+const (
+	ConflictFail   = "fail"   // the clone fails with 406
+	ConflictRename = "rename" // the clone goes to a new name: "Project (2)", "Project (3)"...
+	ConflictMerge  = "merge"  // the files are copied into the existing destination
+)
+
+var (
+	conflictModes    = []string{ConflictFail, ConflictRename, ConflictMerge}
+	shareTypes       = []string{TypeDirectory, TypeFile}
+	shareEntities    = []string{EntityUser, EntityEgroup, EntityUnixGroup, EntityEmail}
+	sharePermissions = []string{PermRead, PermReadWrite, PermReadWriteShr}
+)
+
+// Sharee is a user or group a project is shared with.
+type Sharee struct {
+	Name        string     `json:"name"`                  // name of user or group
+	Entity      string     `json:"entity"`                // "u" is user, "egroup" is group, "g" is unix group, "email" is guest
+	Permissions string     `json:"permissions,omitempty"` // "r" (default), "rw" or "rw+reshare"
+	Expires     *time.Time `json:"expires,omitempty"`     // the share is removed after this time, nil for never
+}
+
+// validate checks the fields of the sharee and sets the default permissions.
+func (s *Sharee) validate() error {
+	if s.Name == "" || strings.ContainsAny(s.Name, ":/ \t\n") {
+		return fmt.Errorf("invalid sharee name %q", s.Name)
+	}
+	if !stringInSlice(s.Entity, shareEntities) {
+		return fmt.Errorf("invalid entity %q for sharee %s", s.Entity, s.Name)
+	}
+	if s.Entity == EntityEmail {
+		if addr, err := mail.ParseAddress(s.Name); err != nil || addr.Address != s.Name {
+			return fmt.Errorf("invalid email %q", s.Name)
+		}
+	}
+	if s.Permissions == "" {
+		s.Permissions = PermRead
+	}
+	if !stringInSlice(s.Permissions, sharePermissions) {
+		return fmt.Errorf("invalid permissions %q for sharee %s", s.Permissions, s.Name)
+	}
+	if s.Expires != nil && !s.Expires.After(time.Now()) {
+		return fmt.Errorf("expiration date of sharee %s is in the past", s.Name)
+	}
+	return nil
+}
+
+// arg formats the sharee as an argument of the share script: entity:name:permissions,
+// followed by :expires as a unix timestamp if the share expires.
+func (s *Sharee) arg() string {
+	arg := s.Entity + ":" + s.Name + ":" + s.Permissions
+	if s.Expires != nil {
+		arg += ":" + strconv.FormatInt(s.Expires.Unix(), 10)
+	}
+	return arg
+}
+
+// runShareScript runs the share script and returns its JSON output with the status code of the response.
+// If the script fails, the status code is taken from its output, as in CmdError.
+func runShareScript(logger *zap.Logger, cboxShareScript string, args []string) ([]byte, int) {
+	return runShareScriptWithInput(logger, cboxShareScript, args, nil)
+}
+
+// runShareScriptWithInput is like runShareScript, passing input to the script on stdin.
+// It is used for secrets, which must not appear in the command line.
+func runShareScriptWithInput(logger *zap.Logger, cboxShareScript string, args []string, input io.Reader) ([]byte, int) {
+
+	logger.Info(fmt.Sprintf("cmd args %s", args))
+
+	cmd := exec.Command(cboxShareScript, args...)
+	cmd.Stdin = input
+
+	jsonResponse, errBuf, err := executeCMD(cmd)
+
+	if err != nil {
+
+		logger.Error(fmt.Sprintf("Error calling cmd %s %s %s: '%s'", cmd.Path, cmd.Args, err, errBuf.String()))
+
+		cmderr := CmdError{Statuscode: http.StatusInternalServerError}
+		json.Unmarshal(jsonResponse.Bytes(), &cmderr)
+
+		return jsonResponse.Bytes(), cmderr.Statuscode
+	}
+
+	return jsonResponse.Bytes(), http.StatusOK
+}
+
+// PatchShare adds and removes sharees of a project without touching the other ones.
+// The backend applies both lists at once and returns the resulting share state.
+// The email sharees are guest invitations, kept by the daemon.
+func PatchShare(logger *zap.Logger, cboxShareScript string, guests *Guests) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		project := r.URL.Query().Get("project")
+		if project == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: project not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if !checkShareRoot(logger, w, r, project) {
+			return
+		}
+
+		var patch struct {
+			Add    []*Sharee `json:"add"`
+			Remove []*Sharee `json:"remove"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			logger.Error(fmt.Sprintf("Cannot unmarshal JSON request body"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if len(patch.Add) == 0 && len(patch.Remove) == 0 {
+			logger.Error(fmt.Sprintf("Empty request"))
+			writeError(w, http.StatusBadRequest, "nothing to add or remove")
+			return
+		}
+
+		args := []string{"--json", "patch-share", username, path.Clean(project)}
+
+		var addGuests []*Sharee
+		var removeGuests []string
+		added := map[string]bool{}
+		for _, share := range patch.Add {
+			if err := share.validate(); err != nil {
+				logger.Error(fmt.Sprintf("Invalid sharee: %s", err))
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			added[share.Entity+":"+share.Name] = true
+			if share.Entity == EntityEmail {
+				addGuests = append(addGuests, share)
+				continue
+			}
+			args = append(args, "--add", share.arg())
+		}
+
+		for _, share := range patch.Remove {
+			if err := share.validate(); err != nil {
+				logger.Error(fmt.Sprintf("Invalid sharee: %s", err))
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if added[share.Entity+":"+share.Name] {
+				logger.Error(fmt.Sprintf("Sharee %s is both added and removed", share.Name))
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("sharee %s is both added and removed", share.Name))
+				return
+			}
+			if share.Entity == EntityEmail {
+				removeGuests = append(removeGuests, share.Name)
+				continue
+			}
+			args = append(args, "--remove", share.Entity+":"+share.Name)
+		}
+
+		guestsOnly := len(addGuests)+len(removeGuests) == len(patch.Add)+len(patch.Remove)
+		var jsonResponse []byte
+		statusCode := http.StatusOK
+		if !guestsOnly {
+			jsonResponse, statusCode = runShareScript(logger, cboxShareScript, args)
+		}
+		if statusCode == http.StatusOK && (len(addGuests) > 0 || len(removeGuests) > 0) {
+			if err := guests.update(username, project, addGuests, removeGuests, false); err != nil {
+				logger.Error(fmt.Sprintf("Error storing guest invitations: %s", err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		if guestsOnly {
+			guestListing(logger, w, r, cboxShareScript, guests, username, project)
+			return
+		}
+		if statusCode == http.StatusOK {
+			jsonResponse = withGuests(r, username, guests, jsonResponse)
+			setShareETag(w, jsonResponse)
+		}
+		w.WriteHeader(statusCode)
+		w.Write(jsonResponse)
+	})
+}
+
+// cloneRequest is the optional body of a clone, restricting the files cloned.
+// The patterns are paths relative to the project, where the last elements can
+// use the wildcards of path.Match: "*.ipynb", "notebooks/*", "data/run[12].csv".
+type cloneRequest struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// args returns the arguments of the share script applying the filter during the copy.
+func (c *cloneRequest) args() ([]string, error) {
+	var args []string
+	for _, list := range []struct {
+		option   string
+		patterns []string
+	}{{"--include", c.Include}, {"--exclude", c.Exclude}} {
+		for _, pattern := range list.patterns {
+			if !validHomePath(pattern) {
+				return nil, fmt.Errorf("invalid pattern %q", pattern)
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+			}
+			args = append(args, list.option, path.Clean(pattern))
+		}
+	}
+	return args, nil
+}
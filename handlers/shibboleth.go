@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ShibConfig holds the names of the request headers set by shibd after a
+// successful login. The names depend on the attribute-map.xml of the shibd
+// deployment in front of the daemon.
+type ShibConfig struct {
+	LoginHeader       string
+	DisplayNameHeader string
+	EmailHeader       string
+	GroupsHeader      string
+	// TokenGroups are the groups kept in the identity, the only ones the
+	// daemon uses: the users can belong to hundreds of e-groups, which would
+	// not fit the Authorization header of the requests once in the token.
+	TokenGroups []string
+	// TrustedProxies, if not empty, restricts the identity headers to
+	// requests coming from these addresses, so they cannot be spoofed by
+	// clients reaching the daemon directly.
+	TrustedProxies []*net.IPNet
+}
+
+// ShibIdentity is the identity asserted by shibd for a request.
+type ShibIdentity struct {
+	Username    string
+	DisplayName string
+	Email       string
+	Groups      []string
+}
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR
+// ranges. An empty string returns an empty list.
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, entry := range splitList(list, ",") {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address: %s", entry)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range: %s", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// splitList splits s by sep, trimming spaces and dropping empty elements.
+func splitList(s, sep string) []string {
+	var list []string
+	for _, v := range strings.Split(s, sep) {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// fromTrustedProxy returns true if the request comes from one of the
+// trusted proxies or if no trusted proxies are configured.
+func (c *ShibConfig) fromTrustedProxy(r *http.Request) bool {
+	if len(c.TrustedProxies) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range c.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// identity extracts the shibd identity from the request headers.
+// ADFS sends multi-valued attributes, like the group membership, separated by ';'.
+func (c *ShibConfig) identity(r *http.Request) *ShibIdentity {
+	id := &ShibIdentity{Username: r.Header.Get(c.LoginHeader)}
+	if c.DisplayNameHeader != "" {
+		id.DisplayName = r.Header.Get(c.DisplayNameHeader)
+	}
+	if c.EmailHeader != "" {
+		id.Email = r.Header.Get(c.EmailHeader)
+	}
+	if c.GroupsHeader != "" {
+		for _, group := range splitList(r.Header.Get(c.GroupsHeader), ";") {
+			if stringInSlice(group, c.TokenGroups) {
+				id.Groups = append(id.Groups, group)
+			}
+		}
+	}
+	return id
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestShibIdentityKeepsTokenGroups(t *testing.T) {
+	c := &ShibConfig{LoginHeader: "adfs_login", GroupsHeader: "adfs_group", TokenGroups: []string{"swan-admins", "it-dep"}}
+	r := httptest.NewRequest("GET", "/swanapi/v1/authenticate", nil)
+	r.Header.Set("adfs_login", "bob")
+	r.Header.Set("adfs_group", "cern-users; swan-admins;physics-lovers;it-dep")
+	id := c.identity(r)
+	if id.Username != "bob" || !reflect.DeepEqual(id.Groups, []string{"swan-admins", "it-dep"}) {
+		t.Errorf("unexpected identity %+v", id)
+	}
+
+	c.TokenGroups = nil
+	if id := c.identity(r); len(id.Groups) != 0 {
+		t.Errorf("got groups %v, want none", id.Groups)
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+// StarredProject is a project the user has starred, one of theirs or one shared with them.
+type StarredProject struct {
+	Owner   string    `json:"owner"`
+	Project string    `json:"project"`
+	Starred time.Time `json:"starred"`
+}
+
+// Starred keeps the projects each user has starred, with the time they starred them.
+type Starred struct {
+	prefs *sharePrefs
+}
+
+// NewStarred returns the starred projects kept in the store.
+func NewStarred(store Store) *Starred {
+	return &Starred{prefs: &sharePrefs{store: store, bucket: "starred"}}
+}
+
+// starKey identifies a project of owner in the starred projects, whether the
+// path has a trailing slash or not.
+func starKey(owner, project string) string {
+	return owner + ":" + path.Clean(project)
+}
+
+// Filter is a listing filter setting the starred flag of each project. With
+// owned, the projects are the ones of the user, otherwise the ones shared with them.
+func (s *Starred) Filter(owned bool) ListingFilter {
+	return func(r *http.Request, username string, listing *Listing) error {
+		starred, err := s.prefs.get(username)
+		if err != nil {
+			return err
+		}
+		for _, share := range listing.Shares {
+			owner := username
+			if !owned {
+				owner, _ = share["shared_by"].(string)
+			}
+			project, _ := share["project"].(string)
+			_, isStarred := starred[starKey(owner, project)]
+			share["starred"] = isStarred
+		}
+		return nil
+	}
+}
+
+// ListStarred lists the projects starred by the user, the most recently starred first.
+func ListStarred(logger *zap.Logger, s *Starred) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		starred, err := s.prefs.get(username)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error loading starred projects: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		list := []*StarredProject{}
+		for key, value := range starred {
+			parts := strings.SplitN(key, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			t, _ := time.Parse(time.RFC3339, value)
+			list = append(list, &StarredProject{Owner: parts[0], Project: parts[1], Starred: t})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Starred.After(list[j].Starred) })
+
+		encoded, _ := json.Marshal(map[string]interface{}{"starred": list})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
+
+// StarProject stars (or, if star is false, unstars) a project of the user or,
+// with the sharer query parameter, a project shared with them.
+func StarProject(logger *zap.Logger, cboxShareScript string, s *Starred, star bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		project := r.URL.Query().Get("project")
+		if project == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: project not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		owner := username
+		if sharer := r.URL.Query().Get("sharer"); sharer != "" {
+			// A project can be unstarred after it is no longer shared with the user.
+			if star && checkSharedWith(logger, w, cboxShareScript, username, sharer, project) == nil {
+				return
+			}
+			owner = sharer
+		} else if !checkShareRoot(logger, w, r, project) {
+			return
+		}
+
+		starred := &StarredProject{Owner: owner, Project: path.Clean(project), Starred: time.Now().UTC()}
+		value := ""
+		if star {
+			value = starred.Starred.Format(time.RFC3339)
+		}
+		if err := s.prefs.set(username, starKey(owner, project), value); err != nil {
+			logger.Error(fmt.Sprintf("Error storing starred project: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if !star {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		encoded, _ := json.Marshal(starred)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists the data kept by the daemon itself, like the preferences of
+// the users about the projects shared with them. Values are grouped in buckets
+// and encoded as JSON.
+type Store interface {
+	// Get decodes the value of key into v and returns false if it does not exist.
+	Get(bucket, key string, v interface{}) (bool, error)
+	// Put stores the value of key.
+	Put(bucket, key string, v interface{}) error
+	// Delete removes key, if it exists.
+	Delete(bucket, key string) error
+	// Keys returns the keys of the bucket.
+	Keys(bucket string) ([]string, error)
+}
+
+// FileStore is a Store keeping all the data in a single JSON file, which is
+// rewritten atomically on every change. It is meant for a single daemon
+// with a moderate amount of data.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	data map[string]map[string]json.RawMessage
+}
+
+// NewFileStore loads the store from path, which is created on the first change if it does not exist.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, data: map[string]map[string]json.RawMessage{}}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return nil, fmt.Errorf("error parsing store %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// Get implements Store.
+func (s *FileStore) Get(bucket, key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, ok := s.data[bucket][key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Put implements Store.
+func (s *FileStore) Put(bucket, key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data[bucket] == nil {
+		s.data[bucket] = map[string]json.RawMessage{}
+	}
+	s.data[bucket][key] = raw
+	return s.save()
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[bucket][key]; !ok {
+		return nil
+	}
+	delete(s.data[bucket], key)
+	return s.save()
+}
+
+// Keys implements Store.
+func (s *FileStore) Keys(bucket string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for key := range s.data[bucket] {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *FileStore) save() error {
+	raw, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// newID returns a random identifier for the objects kept by the daemon.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// userLocks serializes the read-modify-write of the data of each user in the store.
+var userLocks = newKeyLocks()
+
+// updateUserData loads the data of the user from the bucket into v, calls update
+// and stores v back, holding the lock of the user.
+func updateUserData(store Store, bucket, username string, v interface{}, update func() error) error {
+	userLocks.lock(bucket + ":" + username)
+	defer userLocks.unlock(bucket + ":" + username)
+	if _, err := store.Get(bucket, username, v); err != nil {
+		return err
+	}
+	if err := update(); err != nil {
+		return err
+	}
+	return store.Put(bucket, username, v)
+}
+
+// sharePrefs keeps, in a store bucket, a value for each user and project shared
+// with them, identified by shareKey, so it survives the modifications of the share.
+type sharePrefs struct {
+	store  Store
+	bucket string
+}
+
+func (p *sharePrefs) get(username string) (map[string]string, error) {
+	values := map[string]string{}
+	if _, err := p.store.Get(p.bucket, username, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// set stores the value for the share, an empty value removes it.
+func (p *sharePrefs) set(username, key, value string) error {
+	values := map[string]string{}
+	return updateUserData(p.store, p.bucket, username, &values, func() error {
+		if value == "" {
+			delete(values, key)
+		} else {
+			values[key] = value
+		}
+		return nil
+	})
+}
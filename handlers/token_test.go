@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/context"
+	"go.uber.org/zap"
+)
+
+func TestTokenExpires(t *testing.T) {
+	r := httptest.NewRequest("GET", "/swanapi/v2/authenticate", nil)
+	context.Set(r, "username", "bob")
+	context.Set(r, "origin", &OriginSettings{TokenLifetime: 60})
+	rec := httptest.NewRecorder()
+	Token2(zap.NewNop(), "key").ServeHTTP(rec, r)
+	context.Clear(r)
+
+	var response struct {
+		Token string `json:"authtoken"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	token, err := jwt.Parse(response.Token, func(*jwt.Token) (interface{}, error) { return []byte("key"), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, _ := token.Claims.(jwt.MapClaims)["exp"].(float64)
+	if d := time.Until(time.Unix(int64(exp), 0)); d <= 0 || d > time.Minute {
+		t.Errorf("token expires in %s, want a minute", d)
+	}
+
+	handler := CheckJWTToken(zap.NewNop(), "key", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r = httptest.NewRequest("GET", "/swanapi/v1/shared", nil)
+	r.Header.Set("Authorization", "Bearer "+signedToken(t, jwt.MapClaims{"username": "bob", "exp": time.Now().Add(-time.Minute).Unix()}))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	context.Clear(r)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired token: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Transfer is a proposal to transfer the ownership of a project to another user.
+type Transfer struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"owner"`
+	Project    string    `json:"project"`
+	Recipient  string    `json:"recipient"`
+	ProposedBy string    `json:"proposed_by"`
+	Created    time.Time `json:"created"`
+}
+
+// Transfers keeps the pending ownership transfers. Each transfer is stored by
+// id, and the ids of the transfers of each user, as owner or recipient, are
+// kept in a separate bucket to list them.
+type Transfers struct {
+	store Store
+}
+
+const (
+	transfersBucket     = "transfers"
+	userTransfersBucket = "user-transfers"
+)
+
+// NewTransfers returns the transfers kept in the store.
+func NewTransfers(store Store) *Transfers {
+	return &Transfers{store: store}
+}
+
+// updateIndex adds or removes the transfer id from the transfers of the user.
+func (t *Transfers) updateIndex(username, id string, add bool) error {
+	ids := []string{}
+	return updateUserData(t.store, userTransfersBucket, username, &ids, func() error {
+		kept := []string{}
+		for _, i := range ids {
+			if i != id {
+				kept = append(kept, i)
+			}
+		}
+		if add {
+			kept = append(kept, id)
+		}
+		ids = kept
+		return nil
+	})
+}
+
+func (t *Transfers) create(transfer *Transfer) error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	transfer.ID = id
+	if err := t.store.Put(transfersBucket, id, transfer); err != nil {
+		return err
+	}
+	if err := t.updateIndex(transfer.Owner, id, true); err != nil {
+		return err
+	}
+	return t.updateIndex(transfer.Recipient, id, true)
+}
+
+// get returns the transfer, or nil if it does not exist.
+func (t *Transfers) get(id string) (*Transfer, error) {
+	transfer := &Transfer{}
+	found, err := t.store.Get(transfersBucket, id, transfer)
+	if err != nil || !found {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// list returns the transfers of the user, as owner or recipient.
+func (t *Transfers) list(username string) ([]*Transfer, error) {
+	ids := []string{}
+	if _, err := t.store.Get(userTransfersBucket, username, &ids); err != nil {
+		return nil, err
+	}
+	transfers := []*Transfer{}
+	for _, id := range ids {
+		transfer, err := t.get(id)
+		if err != nil {
+			return nil, err
+		}
+		if transfer != nil {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers, nil
+}
+
+func (t *Transfers) remove(transfer *Transfer) error {
+	if err := t.store.Delete(transfersBucket, transfer.ID); err != nil {
+		return err
+	}
+	if err := t.updateIndex(transfer.Owner, transfer.ID, false); err != nil {
+		return err
+	}
+	return t.updateIndex(transfer.Recipient, transfer.ID, false)
+}
+
+// ProposeTransfer proposes to transfer a project of the user to another user.
+// Admins can propose the transfer of the projects of any user, given by the owner query parameter.
+func ProposeTransfer(logger *zap.Logger, transfers *Transfers, admins *Admins) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		query := r.URL.Query()
+		project, recipient := query.Get("project"), query.Get("recipient")
+		if project == "" || recipient == "" {
+			logger.Error(fmt.Sprintf("URL missing query parameter: project or recipient not specified"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		owner := query.Get("owner")
+		if owner == "" {
+			owner = username
+		}
+		if !checkUsername(logger, w, "recipient", recipient) || !checkUsername(logger, w, "owner", owner) {
+			return
+		}
+		if owner != username && !admins.isAdmin(r) {
+			logger.Error(fmt.Sprintf("User %s is not allowed to transfer the projects of %s", username, owner))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if !checkShareRoot(logger, w, r, project) {
+			return
+		}
+
+		if recipient == owner {
+			logger.Error(fmt.Sprintf("Transfer of project '%s' to its owner %s", project, owner))
+			writeError(w, http.StatusBadRequest, "the recipient already owns the project")
+			return
+		}
+
+		transfer := &Transfer{
+			Owner:      owner,
+			Project:    project,
+			Recipient:  recipient,
+			ProposedBy: username,
+			Created:    time.Now().UTC(),
+		}
+		if err := transfers.create(transfer); err != nil {
+			logger.Error(fmt.Sprintf("Error storing transfer: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info(fmt.Sprintf("Transfer %s of project '%s' of %s to %s proposed by %s", transfer.ID, project, owner, recipient, username))
+
+		encoded, _ := json.Marshal(transfer)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
+
+// ListTransfers lists the pending transfers of the user, as owner or recipient.
+func ListTransfers(logger *zap.Logger, transfers *Transfers) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		list, err := transfers.list(username)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error loading transfers: %s", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		encoded, _ := json.Marshal(map[string]interface{}{"transfers": list})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	})
+}
+
+// AcceptTransfer accepts a transfer proposed to the user: the share script moves
+// the project to the home of the user and re-creates its shares under the new
+// owner, and the pending guest invitations and the metadata of the project follow.
+func AcceptTransfer(logger *zap.Logger, cboxShareScript string, transfers *Transfers, guests *Guests, projectsMetadata *ProjectsMetadata) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		id := mux.Vars(r)["id"]
+		transfer, err := transfers.get(id)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error loading transfer %s: %s", id, err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if transfer == nil || transfer.Recipient != username {
+			logger.Error(fmt.Sprintf("Transfer %s not found for %s", id, username))
+			writeError(w, http.StatusNotFound, "transfer not found")
+			return
+		}
+
+		// The shares of the project must not change while it is moved.
+		key := shareLockKey(transfer.Owner, transfer.Project)
+		shareLocks.lock(key)
+		defer shareLocks.unlock(key)
+
+		// Check again that the transfer was not accepted or withdrawn meanwhile.
+		if transfer, err = transfers.get(id); err != nil || transfer == nil {
+			logger.Error(fmt.Sprintf("Transfer %s withdrawn", id))
+			writeError(w, http.StatusNotFound, "transfer not found")
+			return
+		}
+
+		args := []string{"--json", "transfer-project", transfer.Owner, path.Clean(transfer.Project), transfer.Recipient}
+
+		jsonResponse, statusCode := runShareScript(logger, cboxShareScript, args)
+		if statusCode == http.StatusOK {
+			logger.Info(fmt.Sprintf("Project '%s' of %s transferred to %s", transfer.Project, transfer.Owner, transfer.Recipient))
+			if err := transfers.remove(transfer); err != nil {
+				logger.Error(fmt.Sprintf("Error removing transfer %s: %s", id, err))
+			}
+			if err := guests.transfer(transfer.Owner, transfer.Project, transfer.Recipient); err != nil {
+				logger.Error(fmt.Sprintf("Error moving the guest invitations of transfer %s: %s", id, err))
+			}
+			if err := projectsMetadata.transfer(transfer.Owner, transfer.Project, transfer.Recipient); err != nil {
+				logger.Error(fmt.Sprintf("Error moving the metadata of transfer %s: %s", id, err))
+			}
+		}
+		w.WriteHeader(statusCode)
+		w.Write(jsonResponse)
+	})
+}
+
+// DeleteTransfer declines a transfer proposed to the user, or withdraws a
+// transfer of a project of the user or proposed by them. Admins can withdraw any transfer.
+func DeleteTransfer(logger *zap.Logger, transfers *Transfers, admins *Admins) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !checkOrigin(logger, w, r) {
+			return
+		}
+
+		v := context.Get(r, "username")
+		username, _ := v.(string)
+
+		logger.Info("loggedin user is " + username)
+
+		id := mux.Vars(r)["id"]
+		transfer, err := transfers.get(id)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error loading transfer %s: %s", id, err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if transfer == nil || !(stringInSlice(username, []string{transfer.Owner, transfer.Recipient, transfer.ProposedBy}) || admins.isAdmin(r)) {
+			logger.Error(fmt.Sprintf("Transfer %s not found for %s", id, username))
+			writeError(w, http.StatusNotFound, "transfer not found")
+			return
+		}
+
+		if err := transfers.remove(transfer); err != nil {
+			logger.Error(fmt.Sprintf("Error removing transfer %s: %s", id, err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
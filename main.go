@@ -6,6 +6,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/cernbox/cboxswanapid/handlers"
 	"github.com/cernbox/gohub/goconfig"
@@ -38,12 +40,37 @@ func init() {
 	gc.Add("signkey", "changeme", "Secret to sign JWT tokens")
 	gc.Add("swanclient", "swan-service", "SWAN client id")
 	gc.Add("oidcprovider", "https://auth.cern.ch/auth/realms/cern", "OIDC endpoint")
-	gc.Add("allowfrom", "swan[a-z0-9-]*.cern.ch", "Check the Referer/Origin request header (depending on the endpoint) and return Bad Request if no match.")
+	gc.Add("guestoidcprovider", "", "OIDC endpoint of the external accounts realm, where the guests invited by email log in (empty to disable)")
+	gc.Add("allowfrom", "swan*.cern.ch", "Comma separated list of allowed origins (e.g. swan.cern.ch, swan*.cern.ch, *.cern.ch:8443). Check the Referer/Origin request header (depending on the endpoint) and return Bad Request if no match.")
+	gc.Add("originsconfig", "", "JSON file with the list of allowed origins with their own settings (token_lifetime, scopes, share_root)")
+	gc.Add("tokenlifetime", 3600, "Default validity of the tokens in seconds")
+	gc.Add("scopes", "", "Default comma separated list of scopes granted to the allowed origins (read, share, clone, search). Empty means all")
+	gc.Add("shareroot", "", "Default directory, relative to the user home, where the shared projects must live. Empty means no restriction")
 	gc.Add("shibreferer", "https://login.cern.ch", "Shibolleth referer for /authenticate request.")
+	gc.Add("shibloginheader", "adfs_login", "Request header set by shibd with the login name of the user")
+	gc.Add("shibnameheader", "adfs_fullname", "Request header set by shibd with the display name of the user (empty to disable)")
+	gc.Add("shibemailheader", "adfs_email", "Request header set by shibd with the email of the user (empty to disable)")
+	gc.Add("shibgroupsheader", "adfs_group", "Request header set by shibd with the ';' separated group membership of the user (empty to disable)")
+	gc.Add("shibtrustedproxies", "", "Comma separated list of IPs/CIDRs allowed to set the shibd headers (empty to trust any)")
+	gc.Add("corsallowedheaders", "Authorization,Content-Type,If-Match,X-Link-Password", "Comma separated list of request headers allowed in CORS requests")
+	gc.Add("corsexposedheaders", "ETag", "Comma separated list of response headers exposed to CORS requests")
+	gc.Add("corsallowcredentials", false, "Allow CORS requests with credentials")
+	gc.Add("corsmaxage", 600, "Time in seconds the CORS preflight responses can be cached")
+	gc.Add("linkurl", "https://swan.cern.ch/link/{token}", "URL of the read-only viewer opening a public link, {token} is replaced by the link token")
+	gc.Add("storefile", "/var/lib/cboxswanapid/store.json", "JSON file where the daemon keeps the preferences of the users")
+	gc.Add("adminusers", "", "Comma separated list of users allowed to transfer the projects of any user")
+	gc.Add("admingroups", "", "Comma separated list of groups whose members are allowed to transfer the projects of any user")
 	gc.Add("cboxgroupdsecret", "", "Shared secret to communicate with the cboxgroupd daemon")
 	gc.Add("cboxgroupdurl", "http://localhost:2002/api/v1/search", "URL to address the cboxgroupd daemon")
 	gc.Add("config", "", "Configuration file to use")
 	gc.Add("cboxsharescript", "/b/dev/kuba/devel.cernbox_utils/cernbox-swan-project", "Path to the cernbox share script")
+	gc.Add("checkresponses", false, "Log the responses not conforming to the OpenAPI document (for test deployments)")
+	gc.Add("show-routes", false, "Print the API routes as a markdown table and exit")
+	gc.Add("sharereaperinterval", 3600, "Interval in seconds between the removals of the expired shares (0 to disable)")
+	gc.Add("archivemaxsize", 1073741824, "Maximum size in bytes of the projects downloaded as an archive (0 for no limit)")
+	gc.Add("previewmaxsize", 1048576, "Maximum size in bytes of the files of the shared projects that can be previewed")
+	gc.Add("notebookmaxsize", 10485760, "Maximum size in bytes of the notebooks read for the notebook details of the shared projects")
+	gc.Add("jobretention", 86400, "Time in seconds the finished clone jobs are kept")
 	gc.Add("log-level", "info", "log level to use (debug, info, warn, error)")
 	gc.BindFlags()
 	gc.ReadConfig()
@@ -55,42 +82,82 @@ func main() {
 
 	router := mux.NewRouter()
 
+	trustedProxies, err := handlers.ParseTrustedProxies(gc.GetString("shibtrustedproxies"))
+	if err != nil {
+		panic(fmt.Errorf("error configuring shibboleth trusted proxies: %s", err))
+	}
+	shibConfig := &handlers.ShibConfig{
+		LoginHeader:       gc.GetString("shibloginheader"),
+		DisplayNameHeader: gc.GetString("shibnameheader"),
+		EmailHeader:       gc.GetString("shibemailheader"),
+		GroupsHeader:      gc.GetString("shibgroupsheader"),
+		TokenGroups:       getListOption("admingroups"),
+		TrustedProxies:    trustedProxies,
+	}
+
+	origins, err := handlers.NewOrigins(
+		getListOption("allowfrom"),
+		handlers.OriginSettings{
+			TokenLifetime: gc.GetInt("tokenlifetime"),
+			Scopes:        getListOption("scopes"),
+			ShareRoot:     gc.GetString("shareroot"),
+		},
+		gc.GetString("originsconfig"),
+	)
+	if err != nil {
+		panic(fmt.Errorf("error configuring allowed origins: %s", err))
+	}
+
+	corsConfig := &handlers.CORSConfig{
+		AllowedHeaders:   getListOption("corsallowedheaders"),
+		ExposedHeaders:   getListOption("corsexposedheaders"),
+		AllowCredentials: gc.GetBool("corsallowcredentials"),
+		MaxAge:           gc.GetInt("corsmaxage"),
+	}
+
+	store, err := handlers.NewFileStore(gc.GetString("storefile"))
+	if err != nil {
+		panic(fmt.Errorf("error opening store: %s", err))
+	}
+	routes := apiRoutes(logger, store, origins, shibConfig)
+
+	if gc.GetBool("show-routes") {
+		fmt.Print(handlers.RoutesDoc(routes))
+		os.Exit(0)
+	}
+
 	ctx := context.Background()
 	oidcProvider, err := oidc.NewProvider(ctx, gc.GetString("oidcprovider"))
 	if err != nil {
-		panic(fmt.Errorf("error configuring oidc provider", err))
+		panic(fmt.Errorf("error configuring oidc provider: %s", err))
 	}
 	var verifier = oidcProvider.Verifier(&oidc.Config{ClientID: gc.GetString("swanclient")})
 
-	tokenHandler := handlers.CheckNothing(logger, handlers.Token(logger, gc.GetString("signkey"), gc.GetString("allowfrom"), gc.GetString("shibreferer")))
-	tokenHandler2 := handlers.CheckOIDCToken(logger, ctx, verifier, handlers.Token2(logger, gc.GetString("signkey")), gc.GetString("allowfrom"))
-
-	sharedHandler := handlers.CheckJWTToken(logger, gc.GetString("signkey"), handlers.Shared(logger, gc.GetString("cboxsharescript"), gc.GetString("allowfrom"), "list-shared-with", false))
-	sharingHandler := handlers.CheckJWTToken(logger, gc.GetString("signkey"), handlers.Shared(logger, gc.GetString("cboxsharescript"), gc.GetString("allowfrom"), "list-shared-by", false))
-	getIndividualShareHandler := handlers.CheckJWTToken(logger, gc.GetString("signkey"), handlers.Shared(logger, gc.GetString("cboxsharescript"), gc.GetString("allowfrom"), "list-shared-by", true))
-	updateShareHandler := handlers.CheckJWTToken(logger, gc.GetString("signkey"), handlers.UpdateShare(logger, gc.GetString("cboxsharescript"), gc.GetString("allowfrom")))
-	deleteShareHandler := handlers.CheckJWTToken(logger, gc.GetString("signkey"), handlers.DeleteShare(logger, gc.GetString("cboxsharescript"), gc.GetString("allowfrom")))
-	searchHandler := handlers.CheckJWTToken(logger, gc.GetString("signkey"), handlers.Search(logger, gc.GetString("allowfrom"), gc.GetString("cboxgroupdurl"), gc.GetString("cboxgroupdsecret")))
-	cloneShareHandler := handlers.CheckJWTToken(logger, gc.GetString("signkey"), handlers.CloneShare(logger, gc.GetString("cboxsharescript"), gc.GetString("allowfrom")))
-	notFoundHandler := handlers.CheckJWTToken(logger, gc.GetString("signkey"), handlers.Handle404(logger))
-
-	router.NotFoundHandler = notFoundHandler // default protection for non-existing resources is JWT
-
-	router.Handle("/swanapi/v1/authenticate", tokenHandler).Methods("GET")
-	router.Handle("/swanapi/v2/authenticate", tokenHandler2).Methods("GET")
-	router.Handle("/swanapi/v1/shared", sharedHandler).Methods("GET")
-	router.Handle("/swanapi/v1/sharing", sharingHandler).Methods("GET")
-	router.Handle("/swanapi/v1/share", getIndividualShareHandler).Methods("GET")
-	router.Handle("/swanapi/v1/share", updateShareHandler).Methods("PUT")
-	router.Handle("/swanapi/v1/share", deleteShareHandler).Methods("DELETE")
-	router.Handle("/swanapi/v1/search", searchHandler).Methods("GET")
-	router.Handle("/swanapi/v1/clone", cloneShareHandler).Methods("POST")
-
-	router.Handle("/swanapi/v1/shared", handlers.Options(logger, []string{"GET"}, gc.GetString("allowfrom"))).Methods("OPTIONS")
-	router.Handle("/swanapi/v1/sharing", handlers.Options(logger, []string{"GET"}, gc.GetString("allowfrom"))).Methods("OPTIONS")
-	router.Handle("/swanapi/v1/share", handlers.Options(logger, []string{"GET", "PUT", "DELETE"}, gc.GetString("allowfrom"))).Methods("OPTIONS")
-	router.Handle("/swanapi/v1/clone", handlers.Options(logger, []string{"POST"}, gc.GetString("allowfrom"))).Methods("OPTIONS")
-	router.Handle("/swanapi/v1/search", handlers.Options(logger, []string{"GET"}, gc.GetString("allowfrom"))).Methods("OPTIONS")
+	guestAuth := func(h http.Handler) http.Handler { return handlers.Handle404(logger) }
+	if provider := gc.GetString("guestoidcprovider"); provider != "" {
+		guestProvider, err := oidc.NewProvider(ctx, provider)
+		if err != nil {
+			panic(fmt.Errorf("error configuring guest oidc provider: %s", err))
+		}
+		guestVerifier := guestProvider.Verifier(&oidc.Config{ClientID: gc.GetString("swanclient")})
+		guestAuth = func(h http.Handler) http.Handler { return handlers.CheckOIDCToken(logger, ctx, guestVerifier, h) }
+	}
+
+	auth := handlers.Authenticators{
+		handlers.AuthNone:       func(h http.Handler) http.Handler { return h },
+		handlers.AuthShibboleth: func(h http.Handler) http.Handler { return handlers.CheckNothing(logger, h) },
+		handlers.AuthOIDC:       func(h http.Handler) http.Handler { return handlers.CheckOIDCToken(logger, ctx, verifier, h) },
+		handlers.AuthJWT:        func(h http.Handler) http.Handler { return handlers.CheckJWTToken(logger, gc.GetString("signkey"), h) },
+		handlers.AuthGuestOIDC:  guestAuth,
+	}
+
+	router.NotFoundHandler = handlers.CheckJWTToken(logger, gc.GetString("signkey"), handlers.Handle404(logger)) // default protection for non-existing resources is JWT
+
+	handlers.RegisterRoutes(logger, router, routes, auth, origins, corsConfig, gc.GetBool("checkresponses"))
+
+	if interval := gc.GetInt("sharereaperinterval"); interval > 0 {
+		handlers.StartShareReaper(logger, gc.GetString("cboxsharescript"), handlers.NewGuests(store), time.Duration(interval)*time.Second)
+	}
 
 	out := getHTTPLoggerOut(gc.GetString("httplog"))
 	loggedRouter := gh.LoggingHandler(out, router)
@@ -99,6 +166,357 @@ func main() {
 	logger.Warn("server stopped", zap.Error(http.ListenAndServe(fmt.Sprintf(":%d", gc.GetInt("port")), loggedRouter)))
 }
 
+// apiRoutes returns the route table of the API, with its handlers.
+func apiRoutes(logger *zap.Logger, store handlers.Store, origins *handlers.Origins, shibConfig *handlers.ShibConfig) []*handlers.Route {
+	invitations := handlers.NewInvitations(store)
+	hiddenShares := handlers.NewHiddenShares(store)
+	transfers := handlers.NewTransfers(store)
+	clones := handlers.NewClones(store)
+	projectsMetadata := handlers.NewProjectsMetadata(store)
+	starred := handlers.NewStarred(store)
+	guests := handlers.NewGuests(store)
+	notebooks := handlers.NewNotebooks(logger, gc.GetString("cboxsharescript"), int64(gc.GetInt("notebookmaxsize")))
+	jobs := handlers.NewJobs(time.Duration(gc.GetInt("jobretention")) * time.Second)
+	admins := &handlers.Admins{Users: getListOption("adminusers"), Groups: getListOption("admingroups")}
+
+	projectParam := handlers.QueryParam("project", "path of the project, or any folder or file, relative to the user home (\"SWAN_projects/Project 1/\")", true)
+	sharerParam := handlers.QueryParam("sharer", "name of the user who shared the project", true)
+	sharedProjectParam := handlers.QueryParam("project", "path of the shared project", true)
+	listingParams := []*handlers.Param{
+		handlers.QueryParam("sharer", "only list the projects shared by this user", false),
+		handlers.QueryParam("sharee", "only list the projects shared with this user or group", false),
+		handlers.QueryParam("tag", "only list the projects with this tag (repeatable)", false),
+		handlers.EnumParam("entity", "only list the projects shared with this kind of sharee", false, handlers.EntityUser, handlers.EntityEgroup, handlers.EntityUnixGroup, handlers.EntityEmail),
+		handlers.DateTimeParam("modified_since", "only list the projects modified since this date"),
+		handlers.EnumParam("sort", "sort key (default name), starred puts the starred projects first", false, handlers.SortKeys...),
+		handlers.EnumParam("order", "sort order (default asc)", false, "asc", "desc"),
+		handlers.IntParam("limit", "maximum number of projects to return", 1),
+		handlers.QueryParam("cursor", "next_cursor of the previous page", false),
+	}
+	ownOrSharedParams := []*handlers.Param{
+		handlers.QueryParam("project", "path of the project, relative to the home of its owner", true),
+		handlers.QueryParam("sharer", "name of the user who shared the project, for a project shared with the user", false),
+	}
+	jobIDParam := handlers.PathParam("id", "id of the job")
+	transferIDParam := handlers.PathParam("id", "id of the transfer")
+	linkTokenParam := handlers.PathParam("token", "token of the public link")
+	linkPasswordParam := handlers.HeaderParam("X-Link-Password", "password of a password-protected link")
+	ifMatchParam := handlers.HeaderParam("If-Match", "ETag of the shares returned by GET /share, the request fails with 412 if they have been modified")
+
+	routes := []*handlers.Route{
+		{
+			Path: "/swanapi/v1/authenticate", Method: "GET", Auth: handlers.AuthShibboleth,
+			Handler:     handlers.Token(logger, gc.GetString("signkey"), origins, gc.GetString("shibreferer"), shibConfig),
+			Description: "Mint a token for the shibboleth user and post it to the SWAN origin",
+			Params:      []*handlers.Param{handlers.QueryParam("Origin", "origin of the SWAN page embedding the iframe", true)},
+		},
+		{
+			Path: "/swanapi/v2/authenticate", Method: "GET", Auth: handlers.AuthOIDC,
+			Handler:     handlers.Token2(logger, gc.GetString("signkey")),
+			Description: "Exchange an OIDC token for a token",
+			Response:    handlers.Ref("Token"),
+		},
+		{
+			Path: "/swanapi/v2/authenticate/guest", Method: "GET", Auth: handlers.AuthGuestOIDC,
+			Handler:     handlers.AcceptGuestInvitations(logger, gc.GetString("cboxsharescript"), guests, handlers.Token2(logger, gc.GetString("signkey"))),
+			Description: "Exchange an OIDC token of the external accounts realm for a token, accepting the invitations to the verified email",
+			Response:    handlers.Ref("Token"),
+		},
+		{
+			Path: "/swanapi/v1/shared", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.Shared(logger, gc.GetString("cboxsharescript"), "list-shared-with", false, invitations.StatusFilter(), hiddenShares.Filter(), projectsMetadata.Filter(false), starred.Filter(false), handlers.PageFilter(), notebooks.Filter()),
+			Description: "List the projects shared with the user",
+			Params: append([]*handlers.Param{
+				handlers.EnumParam("status", "only list the projects with this invitation status", false, handlers.InvitationPending, handlers.InvitationAccepted, handlers.InvitationDeclined, handlers.InvitationMuted),
+				handlers.EnumParam("include_hidden", "also list the hidden projects", false, "true", "false"),
+				handlers.EnumParam("details", "add the metadata of the notebooks of the projects", false, "notebooks"),
+			}, listingParams...),
+			Response: handlers.Ref("ShareList"),
+		},
+		{
+			Path: "/swanapi/v1/shared/hidden", Method: "PUT", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.HideShare(logger, gc.GetString("cboxsharescript"), hiddenShares, true),
+			Description: "Hide a project shared with the user from its listing",
+			Params:      []*handlers.Param{sharerParam, sharedProjectParam},
+			Response:    handlers.Ref("Share"),
+		},
+		{
+			Path: "/swanapi/v1/shared/hidden", Method: "DELETE", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.HideShare(logger, gc.GetString("cboxsharescript"), hiddenShares, false),
+			Description: "Show again a hidden project shared with the user",
+			Params:      []*handlers.Param{sharerParam, sharedProjectParam},
+			Response:    handlers.Ref("Share"),
+		},
+		{
+			Path: "/swanapi/v1/invitations", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.Shared(logger, gc.GetString("cboxsharescript"), "list-shared-with", false, invitations.PendingFilter()),
+			Description: "List the projects shared with the user not yet accepted or declined",
+			Params: []*handlers.Param{
+				handlers.EnumParam("include_muted", "also list the muted invitations", false, "true", "false"),
+			},
+			Response: handlers.Ref("ShareList"),
+		},
+		{
+			Path: "/swanapi/v1/invitations", Method: "PUT", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.UpdateInvitation(logger, gc.GetString("cboxsharescript"), invitations),
+			Description: "Accept, decline or mute a project shared with the user",
+			Params:      []*handlers.Param{sharerParam, sharedProjectParam},
+			Body:        handlers.Ref("InvitationRequest"),
+			Response:    handlers.Ref("Share"),
+		},
+		{
+			Path: "/swanapi/v1/sharing", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.Shared(logger, gc.GetString("cboxsharescript"), "list-shared-by", false, guests.Filter(), clones.Filter(), projectsMetadata.Filter(true), starred.Filter(true), handlers.PageFilter()),
+			Description: "List the projects shared by the user",
+			Params:      listingParams,
+			Response:    handlers.Ref("ShareList"),
+		},
+		{
+			Path: "/swanapi/v1/share", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.Shared(logger, gc.GetString("cboxsharescript"), "list-shared-by", true, guests.Filter(), projectsMetadata.Filter(true), starred.Filter(true)),
+			Description: "Get the shares of a project of the user",
+			Params:      []*handlers.Param{projectParam},
+			Response:    handlers.Ref("ShareList"),
+		},
+		{
+			Path: "/swanapi/v1/share", Method: "PUT", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeShare},
+			Handler:     handlers.CheckIfMatch(logger, gc.GetString("cboxsharescript"), guests, handlers.UpdateShare(logger, gc.GetString("cboxsharescript"), guests)),
+			Description: "Replace the shares of a project of the user",
+			Params:      []*handlers.Param{projectParam, ifMatchParam},
+			Body:        handlers.Ref("ShareRequest"),
+		},
+		{
+			Path: "/swanapi/v1/share", Method: "PATCH", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeShare},
+			Handler:     handlers.CheckIfMatch(logger, gc.GetString("cboxsharescript"), guests, handlers.PatchShare(logger, gc.GetString("cboxsharescript"), guests)),
+			Description: "Add and remove shares of a project of the user",
+			Params:      []*handlers.Param{projectParam, ifMatchParam},
+			Body:        handlers.Ref("PatchShareRequest"),
+			Response:    handlers.Ref("ShareList"),
+		},
+		{
+			Path: "/swanapi/v1/share", Method: "DELETE", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeShare},
+			Handler:     handlers.CheckIfMatch(logger, gc.GetString("cboxsharescript"), guests, handlers.DeleteShare(logger, gc.GetString("cboxsharescript"), guests)),
+			Description: "Remove all the shares of a project of the user",
+			Params:      []*handlers.Param{projectParam, ifMatchParam},
+		},
+		{
+			Path: "/swanapi/v1/share/metadata", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.GetProjectMetadata(logger, gc.GetString("cboxsharescript"), projectsMetadata, int64(gc.GetInt("previewmaxsize"))),
+			Description: "Get the description, tags and README summary of a project of the user, or shared with them",
+			Params:      ownOrSharedParams,
+			Response:    handlers.Ref("ProjectMetadata"),
+		},
+		{
+			Path: "/swanapi/v1/share/metadata", Method: "PUT", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeShare},
+			Handler:     handlers.SetProjectMetadata(logger, projectsMetadata),
+			Description: "Set the description and tags of a project of the user",
+			Params:      []*handlers.Param{projectParam},
+			Body:        handlers.Ref("MetadataRequest"),
+			Response:    handlers.Ref("ProjectMetadata"),
+		},
+		{
+			Path: "/swanapi/v1/transfers", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.ListTransfers(logger, transfers),
+			Description: "List the pending ownership transfers of the user, as owner or recipient",
+			Response:    handlers.Ref("TransferList"),
+		},
+		{
+			Path: "/swanapi/v1/transfers", Method: "POST", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeShare},
+			Handler:     handlers.ProposeTransfer(logger, transfers, admins),
+			Description: "Propose to transfer the ownership of a project to another user",
+			Params: []*handlers.Param{
+				projectParam,
+				handlers.QueryParam("recipient", "name of the new owner", true),
+				handlers.QueryParam("owner", "owner of the project, if not the user (admins only)", false),
+			},
+			Response: handlers.Ref("Transfer"),
+		},
+		{
+			Path: "/swanapi/v1/transfers/{id}/accept", Method: "POST", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeShare},
+			Handler:     handlers.AcceptTransfer(logger, gc.GetString("cboxsharescript"), transfers, guests, projectsMetadata),
+			Description: "Accept the transfer of a project to the user",
+			Params:      []*handlers.Param{transferIDParam},
+		},
+		{
+			Path: "/swanapi/v1/transfers/{id}", Method: "DELETE", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeShare},
+			Handler:     handlers.DeleteTransfer(logger, transfers, admins),
+			Description: "Decline or withdraw an ownership transfer",
+			Params:      []*handlers.Param{transferIDParam},
+		},
+		{
+			Path: "/swanapi/v1/starred", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.ListStarred(logger, starred),
+			Description: "List the projects starred by the user",
+			Response:    handlers.Ref("StarredList"),
+		},
+		{
+			Path: "/swanapi/v1/starred", Method: "PUT", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.StarProject(logger, gc.GetString("cboxsharescript"), starred, true),
+			Description: "Star a project of the user, or shared with them",
+			Params:      ownOrSharedParams,
+			Response:    handlers.Ref("StarredProject"),
+		},
+		{
+			Path: "/swanapi/v1/starred", Method: "DELETE", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.StarProject(logger, gc.GetString("cboxsharescript"), starred, false),
+			Description: "Unstar a project",
+			Params:      ownOrSharedParams,
+			Status:      http.StatusNoContent,
+		},
+		{
+			Path: "/swanapi/v1/share/archive", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.ArchiveShare(logger, gc.GetString("cboxsharescript"), int64(gc.GetInt("archivemaxsize"))),
+			Description: "Download a project shared with the user as a zip or tar.gz archive",
+			Params: []*handlers.Param{
+				sharerParam,
+				sharedProjectParam,
+				handlers.EnumParam("format", "archive format (default zip)", false, handlers.ArchiveZip, handlers.ArchiveTarGz),
+			},
+		},
+		{
+			Path: "/swanapi/v1/share/tree", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.ShareTree(logger, gc.GetString("cboxsharescript")),
+			Description: "List the files and directories of a project shared with the user",
+			Params: []*handlers.Param{
+				sharerParam,
+				sharedProjectParam,
+				handlers.QueryParam("path", "subdirectory to list, relative to the project", false),
+				handlers.IntParam("depth", "number of levels to list (default all)", 1),
+			},
+			Response: handlers.Ref("Tree"),
+		},
+		{
+			Path: "/swanapi/v1/share/file", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.ShareFile(logger, gc.GetString("cboxsharescript"), int64(gc.GetInt("previewmaxsize"))),
+			Description: "Get the content of a small file of a project shared with the user",
+			Params: []*handlers.Param{
+				sharerParam,
+				sharedProjectParam,
+				handlers.QueryParam("path", "path of the file, relative to the project", true),
+			},
+			Response: handlers.Ref("File"),
+		},
+		{
+			Path: "/swanapi/v1/search", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeSearch},
+			Handler:     handlers.Search(logger, gc.GetString("cboxgroupdurl"), gc.GetString("cboxgroupdsecret")),
+			Description: "Search users and groups in the directory",
+			Params:      []*handlers.Param{handlers.QueryParam("filter", "name to search for, prefixed by a: to include service and secondary accounts or g: for unix groups only", true)},
+			Response:    handlers.Ref("AccountList"),
+		},
+		{
+			Path: "/swanapi/v1/clone", Method: "POST", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeClone},
+			Handler:     handlers.CloneShare(logger, gc.GetString("cboxsharescript"), jobs, clones),
+			Description: "Start cloning a project shared with the user",
+			Params: []*handlers.Param{
+				handlers.QueryParam("project", "path of the shared project (\"SWAN_projects/Project 1/\")", true),
+				handlers.QueryParam("sharer", "name of the user who shared the project", true),
+				handlers.QueryParam("destination", "path of the cloned project (\"SWAN_projects/Project 3/\")", true),
+				handlers.EnumParam("on_conflict", "what to do if the destination exists (default fail)", false, handlers.ConflictFail, handlers.ConflictRename, handlers.ConflictMerge),
+			},
+			Body:         handlers.Ref("CloneRequest"),
+			OptionalBody: true,
+			Status:       http.StatusAccepted,
+			Response:     handlers.Ref("Job"),
+		},
+		{
+			Path: "/swanapi/v1/clones", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.ListClones(logger, clones),
+			Description: "List the clones of the user with the project they were cloned from",
+			Response:    handlers.Ref("CloneList"),
+		},
+		{
+			Path: "/swanapi/v1/clones/changes", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.CloneChanges(logger, gc.GetString("cboxsharescript"), clones),
+			Description: "List the files changed in the original project of a clone",
+			Params:      []*handlers.Param{handlers.QueryParam("project", "path of the clone", true)},
+			Response:    handlers.Ref("ChangeList"),
+		},
+		{
+			Path: "/swanapi/v1/clones/pull", Method: "POST", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeClone},
+			Handler:      handlers.PullClone(logger, gc.GetString("cboxsharescript"), jobs, clones),
+			Description:  "Start copying into a clone the files changed in its original project",
+			Params:       []*handlers.Param{handlers.QueryParam("project", "path of the clone", true)},
+			Body:         handlers.Ref("PullRequest"),
+			OptionalBody: true,
+			Status:       http.StatusAccepted,
+			Response:     handlers.Ref("Job"),
+		},
+		{
+			Path: "/swanapi/v1/jobs", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeClone},
+			Handler:     handlers.ListJobs(logger, jobs),
+			Description: "List the clone and pull jobs of the user",
+			Response:    handlers.Ref("JobList"),
+		},
+		{
+			Path: "/swanapi/v1/jobs/{id}", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeClone},
+			Handler:     handlers.GetJob(logger, jobs),
+			Description: "Get the state and progress of a job",
+			Params:      []*handlers.Param{jobIDParam},
+			Response:    handlers.Ref("Job"),
+		},
+		{
+			Path: "/swanapi/v1/jobs/{id}", Method: "DELETE", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeClone},
+			Handler:     handlers.CancelJob(logger, jobs),
+			Description: "Cancel a job",
+			Params:      []*handlers.Param{jobIDParam},
+			Response:    handlers.Ref("Job"),
+		},
+		{
+			Path: "/swanapi/v1/links", Method: "GET", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.ListLinks(logger, gc.GetString("cboxsharescript"), gc.GetString("linkurl")),
+			Description: "List the public links of the user",
+			Params:      []*handlers.Param{handlers.QueryParam("project", "only list the links of this project", false)},
+			Response:    handlers.Ref("LinkList"),
+		},
+		{
+			Path: "/swanapi/v1/links", Method: "POST", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeShare},
+			Handler:      handlers.CreateLink(logger, gc.GetString("cboxsharescript"), gc.GetString("linkurl")),
+			Description:  "Create a public read-only link to a project of the user",
+			Params:       []*handlers.Param{projectParam},
+			Body:         handlers.Ref("LinkRequest"),
+			OptionalBody: true,
+			Response:     handlers.Ref("Link"),
+		},
+		{
+			Path: "/swanapi/v1/links/{token}", Method: "DELETE", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeShare},
+			Handler:     handlers.DeleteLink(logger, gc.GetString("cboxsharescript")),
+			Description: "Revoke a public link of the user",
+			Params:      []*handlers.Param{linkTokenParam},
+		},
+		{
+			Path: "/swanapi/v1/public/{token}", Method: "GET", Auth: handlers.AuthNone, Scopes: []string{handlers.ScopeRead},
+			Handler:     handlers.ResolveLink(logger, gc.GetString("cboxsharescript"), gc.GetString("linkurl")),
+			Description: "Get the project a public link points to",
+			Params:      []*handlers.Param{linkTokenParam, linkPasswordParam},
+			Response:    handlers.Ref("Link"),
+		},
+		{
+			Path: "/swanapi/v1/public/{token}/clone", Method: "POST", Auth: handlers.AuthJWT, Scopes: []string{handlers.ScopeClone},
+			Handler:     handlers.CloneLink(logger, gc.GetString("cboxsharescript"), jobs),
+			Description: "Start cloning the project a public link points to",
+			Params: []*handlers.Param{
+				linkTokenParam,
+				linkPasswordParam,
+				handlers.QueryParam("destination", "path of the cloned project (\"SWAN_projects/Project 3/\")", true),
+			},
+			Status:   http.StatusAccepted,
+			Response: handlers.Ref("Job"),
+		},
+	}
+	routes = append(routes, &handlers.Route{
+		Path: "/swanapi/openapi.json", Method: "GET", Auth: handlers.AuthNone,
+		Handler:     handlers.OpenAPIDoc(logger, &routes, apiVersion()),
+		Description: "OpenAPI document of the API",
+	})
+
+	return routes
+}
+
+// getListOption returns the elements of a comma separated configuration option.
+func getListOption(key string) []string {
+	return strings.FieldsFunc(gc.GetString(key), func(c rune) bool { return c == ',' || c == ' ' })
+}
+
 func getHTTPLoggerOut(filename string) *os.File {
 	if filename == "stderr" {
 		return os.Stderr
@@ -113,6 +531,14 @@ func getHTTPLoggerOut(filename string) *os.File {
 	}
 }
 
+// apiVersion returns the version reported in the OpenAPI document.
+func apiVersion() string {
+	if gitNearestTag != "" {
+		return gitNearestTag
+	}
+	return "dev"
+}
+
 func showVersion() {
 	// if gitTag is not empty we are on release build
 	if gitTag != "" {
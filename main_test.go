@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cernbox/cboxswanapid/handlers"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// stubShareScript answers every command of the share script with a fixed
+// output of the shape the daemon expects.
+const stubShareScript = `#!/bin/bash
+share='"path":"/eos/user/a/alice/SWAN_projects/A/","type":"directory","modified":"2020-01-01T00:00:00Z","size":1024'
+link='{"token":"t0k3n","project":"SWAN_projects/B/","shared_by":"bob","path":"/eos/user/b/bob/SWAN_projects/B/","created":"2020-01-01T00:00:00Z"}'
+case "$2" in
+list-shared-with)
+	echo '{"shares":[{"project":"SWAN_projects/A/","shared_by":"alice",'"$share"',"shared_with":[{"name":"bob","entity":"u","permissions":"r"}]}]}';;
+list-shared-by|update-share|patch-share)
+	echo '{"shares":[{"project":"SWAN_projects/B/","shared_by":"bob",'"$share"',"shared_with":[{"name":"alice","entity":"u","permissions":"r"}]}]}';;
+list-share-tree)
+	echo '{"entries":[{"path":"analysis.ipynb","type":"file","size":120,"modified":"2020-01-01T00:00:00Z"}]}';;
+read-share-file)
+	echo '{"path":"analysis.ipynb","size":120,"encoding":"utf-8","content":"{\"metadata\":{\"kernelspec\":{\"name\":\"python3\",\"language\":\"python\"}},\"cells\":[]}"}';;
+diff-clone)
+	echo '{"changes":[{"path":"analysis.ipynb","change":"modified"}]}';;
+clone-share)
+	echo '{"project":"SWAN_projects/C/"}';;
+pull-clone)
+	sleep 10; echo '{}';;
+create-link|resolve-link)
+	echo "$link";;
+list-links)
+	echo '{"links":['"$link"']}';;
+*)
+	echo '{}';;
+esac
+`
+
+func TestRouteResponses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cboxswanapid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "share-script")
+	if err := ioutil.WriteFile(script, []byte(stubShareScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	groupd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"account_type":"primary","cn":"alice","display_name":"Alice","mail":"alice@cern.ch"}]`))
+	}))
+	defer groupd.Close()
+	viper.Set("cboxsharescript", script)
+	viper.Set("cboxgroupdurl", groupd.URL)
+
+	logger := zap.NewNop()
+	store, err := handlers.NewFileStore(filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins, err := handlers.NewOrigins([]string{"swan.cern.ch"}, handlers.OriginSettings{TokenLifetime: 3600}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := apiRoutes(logger, store, origins, &handlers.ShibConfig{})
+
+	login := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context.Set(r, "username", "bob")
+			context.Set(r, "email", "bob@example.org")
+			context.Set(r, "email_verified", true)
+			h.ServeHTTP(w, r)
+		})
+	}
+	auth := handlers.Authenticators{}
+	for _, mode := range []handlers.AuthMode{handlers.AuthNone, handlers.AuthShibboleth, handlers.AuthOIDC, handlers.AuthJWT, handlers.AuthGuestOIDC} {
+		auth[mode] = login
+	}
+	router := mux.NewRouter()
+	handlers.RegisterRoutes(logger, router, routes, auth, origins, &handlers.CORSConfig{}, false)
+
+	checked := map[*handlers.Route]bool{}
+	call := func(method, path, target string, body interface{}) map[string]interface{} {
+		t.Helper()
+		var route *handlers.Route
+		for _, r := range routes {
+			if r.Method == method && r.Path == path {
+				route = r
+			}
+		}
+		if route == nil {
+			t.Fatalf("no route %s %s", method, path)
+		}
+		var reqBody []byte
+		if body != nil {
+			reqBody, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, target, bytes.NewReader(reqBody))
+		req.Header.Set("Origin", "https://swan.cern.ch")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != route.Status && !(route.Status == 0 && rec.Code == http.StatusOK) {
+			t.Fatalf("%s %s: status %d: %s", method, target, rec.Code, rec.Body.String())
+		}
+		if err := handlers.ValidateResponse(route, rec.Body.Bytes()); err != nil {
+			t.Errorf("%s %s: %s: %s", method, target, err, rec.Body.String())
+		}
+		checked[route] = true
+		var out map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &out)
+		return out
+	}
+	waitJob := func(id string) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if job := call("GET", "/swanapi/v1/jobs/{id}", "/swanapi/v1/jobs/"+id, nil); job["state"] != handlers.JobRunning {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("job %s still running", id)
+	}
+
+	call("GET", "/swanapi/v2/authenticate", "/swanapi/v2/authenticate", nil)
+	call("GET", "/swanapi/v2/authenticate/guest", "/swanapi/v2/authenticate/guest", nil)
+
+	call("GET", "/swanapi/v1/shared", "/swanapi/v1/shared?details=notebooks&sort=starred&limit=10", nil)
+	call("PUT", "/swanapi/v1/shared/hidden", "/swanapi/v1/shared/hidden?sharer=alice&project=SWAN_projects/A/", nil)
+	call("DELETE", "/swanapi/v1/shared/hidden", "/swanapi/v1/shared/hidden?sharer=alice&project=SWAN_projects/A/", nil)
+	call("GET", "/swanapi/v1/invitations", "/swanapi/v1/invitations?include_muted=true", nil)
+	call("PUT", "/swanapi/v1/invitations", "/swanapi/v1/invitations?sharer=alice&project=SWAN_projects/A/", map[string]interface{}{"status": handlers.InvitationAccepted})
+
+	call("GET", "/swanapi/v1/sharing", "/swanapi/v1/sharing", nil)
+	call("GET", "/swanapi/v1/share", "/swanapi/v1/share?project=SWAN_projects/B/", nil)
+	call("PUT", "/swanapi/v1/share", "/swanapi/v1/share?project=SWAN_projects/B/", map[string]interface{}{
+		"share_with": []map[string]interface{}{{"name": "alice", "entity": handlers.EntityUser}},
+	})
+	call("PATCH", "/swanapi/v1/share", "/swanapi/v1/share?project=SWAN_projects/B/", map[string]interface{}{
+		"add": []map[string]interface{}{{"name": "carol", "entity": handlers.EntityUser, "permissions": handlers.PermReadWrite}},
+	})
+
+	// A project shared only with guests is listed with the projects shared in the storage.
+	call("PUT", "/swanapi/v1/share", "/swanapi/v1/share?project=SWAN_projects/D/", map[string]interface{}{
+		"share_with": []map[string]interface{}{{"name": "carol@example.org", "entity": handlers.EntityEmail}},
+	})
+	if shares := call("GET", "/swanapi/v1/sharing", "/swanapi/v1/sharing", nil)["shares"].([]interface{}); len(shares) != 2 {
+		t.Errorf("got %d shares, want the project shared only with guests too", len(shares))
+	}
+	call("DELETE", "/swanapi/v1/share", "/swanapi/v1/share?project=SWAN_projects/D/", nil)
+	if shares := call("GET", "/swanapi/v1/sharing", "/swanapi/v1/sharing", nil)["shares"].([]interface{}); len(shares) != 1 {
+		t.Errorf("got %d shares, want the guest invitations removed with the shares", len(shares))
+	}
+
+	call("PUT", "/swanapi/v1/share/metadata", "/swanapi/v1/share/metadata?project=SWAN_projects/B/", map[string]interface{}{
+		"description": "An analysis", "tags": []string{"physics"},
+	})
+	call("GET", "/swanapi/v1/share/metadata", "/swanapi/v1/share/metadata?project=SWAN_projects/B/", nil)
+	call("GET", "/swanapi/v1/share/metadata", "/swanapi/v1/share/metadata?project=SWAN_projects/A/&sharer=alice", nil)
+
+	call("POST", "/swanapi/v1/transfers", "/swanapi/v1/transfers?project=SWAN_projects/B/&recipient=carol", nil)
+	call("GET", "/swanapi/v1/transfers", "/swanapi/v1/transfers", nil)
+
+	call("PUT", "/swanapi/v1/starred", "/swanapi/v1/starred?project=SWAN_projects/A/&sharer=alice", nil)
+	call("GET", "/swanapi/v1/starred", "/swanapi/v1/starred", nil)
+	call("DELETE", "/swanapi/v1/starred", "/swanapi/v1/starred?project=SWAN_projects/A/&sharer=alice", nil)
+
+	call("GET", "/swanapi/v1/share/tree", "/swanapi/v1/share/tree?sharer=alice&project=SWAN_projects/A/", nil)
+	call("GET", "/swanapi/v1/share/file", "/swanapi/v1/share/file?sharer=alice&project=SWAN_projects/A/&path=analysis.ipynb", nil)
+	call("GET", "/swanapi/v1/search", "/swanapi/v1/search?filter=alice", nil)
+
+	clone := call("POST", "/swanapi/v1/clone", "/swanapi/v1/clone?sharer=alice&project=SWAN_projects/A/&destination=SWAN_projects/C/", nil)
+	waitJob(clone["id"].(string))
+	call("GET", "/swanapi/v1/clones", "/swanapi/v1/clones", nil)
+	call("GET", "/swanapi/v1/clones/changes", "/swanapi/v1/clones/changes?project=SWAN_projects/C/", nil)
+	pull := call("POST", "/swanapi/v1/clones/pull", "/swanapi/v1/clones/pull?project=SWAN_projects/C/", nil)
+	call("GET", "/swanapi/v1/jobs", "/swanapi/v1/jobs", nil)
+	call("DELETE", "/swanapi/v1/jobs/{id}", "/swanapi/v1/jobs/"+pull["id"].(string), nil)
+	waitJob(pull["id"].(string))
+
+	call("POST", "/swanapi/v1/links", "/swanapi/v1/links?project=SWAN_projects/B/", map[string]interface{}{"password": "secret"})
+	call("GET", "/swanapi/v1/links", "/swanapi/v1/links", nil)
+	call("GET", "/swanapi/v1/public/{token}", "/swanapi/v1/public/t0k3n", nil)
+	cloneLink := call("POST", "/swanapi/v1/public/{token}/clone", "/swanapi/v1/public/t0k3n/clone?destination=SWAN_projects/E/", nil)
+	waitJob(cloneLink["id"].(string))
+
+	for _, route := range routes {
+		if route.Response != nil && !checked[route] {
+			t.Errorf("%s %s: response not checked", route.Method, route.Path)
+		}
+	}
+}